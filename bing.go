@@ -7,19 +7,19 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
-
-	"github.com/davecgh/go-spew/spew"
 )
 
-const (
-	bingURI = "https://api.cognitive.microsoft.com/bing/v7.0/search?%s"
-)
+var bingURI = "https://api.cognitive.microsoft.com/bing/v7.0%s?%s"
 
+// BingError is the error response from the Bing APIs.
 type BingError struct {
-	Type   string `json:"_type"`
-	Errors []struct {
+	// StatusCode is the http status code the error came with.
+	StatusCode int    `json:"-"`
+	Type       string `json:"_type"`
+	Errors     []struct {
 		Code      string `json:"code"`
 		SubCode   string `json:"subCode"`
 		Message   string `json:"message"`
@@ -31,40 +31,28 @@ type BingError struct {
 type BingAnswer struct {
 	Type         string `json:"_type"`
 	QueryContext struct {
-		OriginalQuery string `json:"originalQuery"`
+		OriginalQuery           string `json:"originalQuery"`
+		AlteredQuery            string `json:"alteredQuery"`
+		AlterationOverrideQuery string `json:"alterationOverrideQuery"`
+		AdultIntent             bool   `json:"adultIntent"`
 	} `json:"queryContext"`
 	WebPages struct {
-		WebSearchURL          string `json:"webSearchUrl"`
-		TotalEstimatedMatches int    `json:"totalEstimatedMatches"`
-		Value                 []struct {
-			ID               string    `json:"id"`
-			Name             string    `json:"name"`
-			URL              string    `json:"url"`
-			IsFamilyFriendly bool      `json:"isFamilyFriendly"`
-			DisplayURL       string    `json:"displayUrl"`
-			Snippet          string    `json:"snippet"`
-			DateLastCrawled  time.Time `json:"dateLastCrawled"`
-			SearchTags       []struct {
-				Name    string `json:"name"`
-				Content string `json:"content"`
-			} `json:"searchTags,omitempty"`
-			About []struct {
-				Name string `json:"name"`
-			} `json:"about,omitempty"`
-		} `json:"value"`
+		WebSearchURL          string        `json:"webSearchUrl"`
+		TotalEstimatedMatches int           `json:"totalEstimatedMatches"`
+		Value                 []BingWebPage `json:"value"`
 	} `json:"webPages"`
+	News struct {
+		ID    string            `json:"id"`
+		Value []BingNewsArticle `json:"value"`
+	} `json:"news"`
 	Videos struct {
-		WebSearchURL          string `json:"webSearchUrl"`
-		TotalEstimatedMatches int    `json:"totalEstimatedMatches"`
-		Value                 []struct {
-			ID          string `json:"id"`
-			Name        string `json:"name"`
-			Description string `json:"description"`
-			ContentURL  string `json:"contentUrl"`
-			HostPageURL string `json:"hostPageUrl"`
-			Duration    string `json:"duration"`
-		} `json:"value"`
+		ID                    string      `json:"id"`
+		WebSearchURL          string      `json:"webSearchUrl"`
+		TotalEstimatedMatches int         `json:"totalEstimatedMatches"`
+		Value                 []BingVideo `json:"value"`
 	} `json:"videos"`
+	Computation     *BingComputation `json:"computation"`
+	TimeZone        *BingTimeZone    `json:"timeZone"`
 	RelatedSearches struct {
 		ID    string `json:"id"`
 		Value []struct {
@@ -76,8 +64,9 @@ type BingAnswer struct {
 	RankingResponse struct {
 		Mainline struct {
 			Items []struct {
-				AnswerType  string `json:"answerType"`
-				ResultIndex int    `json:"resultIndex"`
+				AnswerType string `json:"answerType"`
+				// ResultIndex is nil when the item is the whole answer.
+				ResultIndex *int `json:"resultIndex"`
 				Value       struct {
 					ID string `json:"id"`
 				} `json:"value"`
@@ -94,76 +83,338 @@ type BingAnswer struct {
 	} `json:"rankingResponse"`
 }
 
-// Bing performs a query and returns a formatted result.
-func Bing(query string, conf *Config) (output string, err error) {
+// BingWebPage is a web page from the Bing Web Search API.
+type BingWebPage struct {
+	ID               string    `json:"id"`
+	Name             string    `json:"name"`
+	URL              string    `json:"url"`
+	IsFamilyFriendly bool      `json:"isFamilyFriendly"`
+	DisplayURL       string    `json:"displayUrl"`
+	Snippet          string    `json:"snippet"`
+	DateLastCrawled  time.Time `json:"dateLastCrawled"`
+	SearchTags       []struct {
+		Name    string `json:"name"`
+		Content string `json:"content"`
+	} `json:"searchTags,omitempty"`
+	About []struct {
+		Name string `json:"name"`
+	} `json:"about,omitempty"`
+}
+
+// BingComputation is the answer to a math expression or unit conversion.
+type BingComputation struct {
+	ID         string `json:"id"`
+	Expression string `json:"expression"`
+	Value      string `json:"value"`
+}
+
+// BingTimeZone is the answer to a question about the time somewhere.
+type BingTimeZone struct {
+	ID              string         `json:"id"`
+	PrimaryCityTime BingCityTime   `json:"primaryCityTime"`
+	OtherCityTimes  []BingCityTime `json:"otherCityTimes"`
+}
+
+// BingCityTime is the time in a location.
+type BingCityTime struct {
+	Location  string `json:"location"`
+	Time      string `json:"time"`
+	UTCOffset string `json:"utcOffset"`
+}
+
+// BingRankedResult is a single result in the order Bing ranked it. Exactly
+// one of the pointers is set, matching AnswerType.
+type BingRankedResult struct {
+	AnswerType  string
+	WebPage     *BingWebPage
+	News        *BingNewsArticle
+	Video       *BingVideo
+	Computation *BingComputation
+	TimeZone    *BingTimeZone
+}
+
+// Ranked returns the results of the mainline in the order Bing ranked them
+// across answer types. Answer types this package does not know are skipped.
+func (b *BingAnswer) Ranked() []BingRankedResult {
+	var ranked []BingRankedResult
+
+	for _, item := range b.RankingResponse.Mainline.Items {
+		switch item.AnswerType {
+		case "WebPages":
+			for _, i := range bingIndexes(item.ResultIndex, len(b.WebPages.Value)) {
+				ranked = append(ranked, BingRankedResult{AnswerType: item.AnswerType, WebPage: &b.WebPages.Value[i]})
+			}
+		case "News":
+			for _, i := range bingIndexes(item.ResultIndex, len(b.News.Value)) {
+				ranked = append(ranked, BingRankedResult{AnswerType: item.AnswerType, News: &b.News.Value[i]})
+			}
+		case "Videos":
+			for _, i := range bingIndexes(item.ResultIndex, len(b.Videos.Value)) {
+				ranked = append(ranked, BingRankedResult{AnswerType: item.AnswerType, Video: &b.Videos.Value[i]})
+			}
+		case "Computation":
+			if b.Computation != nil {
+				ranked = append(ranked, BingRankedResult{AnswerType: item.AnswerType, Computation: b.Computation})
+			}
+		case "TimeZone":
+			if b.TimeZone != nil {
+				ranked = append(ranked, BingRankedResult{AnswerType: item.AnswerType, TimeZone: b.TimeZone})
+			}
+		}
+	}
+
+	return ranked
+}
+
+// bingIndexes returns the indexes a ranking item refers to, which is every
+// result when it has no index.
+func bingIndexes(index *int, length int) []int {
+	if index != nil {
+		if *index < 0 || *index >= length {
+			return nil
+		}
+		return []int{*index}
+	}
+
+	indexes := make([]int, length)
+	for i := range indexes {
+		indexes[i] = i
+	}
+	return indexes
+}
+
+func (b BingError) Error() string {
+	if len(b.Errors) == 0 {
+		return fmt.Sprintf("bing: unknown error (%d)", b.StatusCode)
+	}
+
+	e := b.Errors[0]
+	msg := fmt.Sprintf("bing: %s (%d %s", e.Message, b.StatusCode, e.Code)
+	if len(e.SubCode) != 0 {
+		msg += "/" + e.SubCode
+	}
+	if len(e.Parameter) != 0 {
+		msg += ", parameter " + e.Parameter
+	}
+	return msg + ")"
+}
+
+// bingQuery queries a Bing API endpoint such as /search or /news/search and
+// decodes the response into v.
+func bingQuery(endpoint string, params url.Values, conf *Config, v interface{}) error {
 	if len(conf.BingAPIKey) == 0 {
-		return output, errors.New("cannot use bing search without bing_api_key")
+		return errors.New("cannot use bing search without bing_api_key")
 	}
 
-	params := make(url.Values)
-	params.Set("answerCount", "1")
-	params.Set("count", "1")
-	params.Set("safeSearch", "Moderate")
-	params.Set("q", query)
-	u := fmt.Sprintf(bingURI, params.Encode())
+	u := fmt.Sprintf(bingURI, endpoint, params.Encode())
 
 	req, err := http.NewRequest(http.MethodGet, u, nil)
 	if err != nil {
-		return "", err
+		return err
 	}
 
 	req.Header.Set("Accept", "application/json")
 	req.Header.Set("Ocp-Apim-Subscription-Key", conf.BingAPIKey)
 
-	var resp *http.Response
-	resp, err = http.DefaultClient.Do(req)
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return "", err
+		return err
 	}
 
 	defer resp.Body.Close()
 
+	conf.log().Debug("bing query", "endpoint", endpoint, "query", params.Get("q"), "status", resp.StatusCode)
+
 	b, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return "", err
+		return err
 	}
 
 	if resp.StatusCode != http.StatusOK {
 		if len(b) == 0 {
-			return fmt.Sprintf("\x02Bing: Query returned %d", resp.StatusCode), nil
+			return StatusError{Service: "bing", StatusCode: resp.StatusCode}
 		}
 
-		var errors BingError
+		errors := BingError{StatusCode: resp.StatusCode}
 		if err = json.Unmarshal(b, &errors); err != nil {
-			return "", err
+			return err
 		}
 
-		spew.Dump(errors)
-		return fmt.Sprintf("\x02Bing: Query error %s", errors.Errors[0].Message), nil
+		for _, e := range errors.Errors {
+			conf.log().Warn("bing error",
+				"status", resp.StatusCode,
+				"code", e.Code,
+				"subCode", e.SubCode,
+				"parameter", e.Parameter,
+				"message", e.Message,
+			)
+		}
+		return errors
 	}
 
-	var results BingAnswer
-	if err = json.Unmarshal(b, &results); err != nil {
+	return json.Unmarshal(b, v)
+}
+
+// bingErrOutput turns errors from bingQuery into chat output where the
+// old behavior was to report them rather than fail.
+func bingErrOutput(name string, err error) (string, error) {
+	switch e := err.(type) {
+	case StatusError:
+		return fmt.Sprintf("\x02%s: Query returned %d", name, e.StatusCode), nil
+	case BingError:
+		if len(e.Errors) == 0 {
+			return fmt.Sprintf("\x02%s: Query error", name), nil
+		}
+		return fmt.Sprintf("\x02%s: Query error %s", name, e.Errors[0].Message), nil
+	default:
 		return "", err
 	}
+}
+
+// BingOptions are the parameters shared by the Bing search APIs. Empty
+// fields are left to Bing's defaults or the defaults in the config.
+type BingOptions struct {
+	// Market is the market results come from, for example "en-US".
+	Market string
+	// SetLang is the language of user interface strings, for example "en".
+	SetLang string
+	// Freshness is one of Day, Week or Month.
+	Freshness string
+	// SafeSearch is one of Off, Moderate or Strict.
+	SafeSearch string
+	// Count is the number of results to return.
+	Count int
+	// Offset is the number of results to skip.
+	Offset int
+	// AnswerCount is the number of answer types a web search returns.
+	AnswerCount int
+	// ResponseFilter limits a web search to answer types such as Webpages
+	// or News.
+	ResponseFilter []string
+}
+
+func (b BingOptions) encode(params url.Values, conf *Config) {
+	market, safeSearch := b.Market, b.SafeSearch
+	if len(market) == 0 {
+		market = conf.BingMarket
+	}
+	if len(safeSearch) == 0 {
+		safeSearch = conf.BingSafeSearch
+	}
+
+	setNonEmpty(params, "mkt", market)
+	setNonEmpty(params, "setLang", b.SetLang)
+	setNonEmpty(params, "freshness", b.Freshness)
+	setNonEmpty(params, "safeSearch", safeSearch)
+	if b.Count > 0 {
+		params.Set("count", strconv.Itoa(b.Count))
+	}
+	if b.Offset > 0 {
+		params.Set("offset", strconv.Itoa(b.Offset))
+	}
+	if b.AnswerCount > 0 {
+		params.Set("answerCount", strconv.Itoa(b.AnswerCount))
+	}
+	if len(b.ResponseFilter) != 0 {
+		params.Set("responseFilter", strings.Join(b.ResponseFilter, ","))
+	}
+}
+
+// BingResults performs a web search and returns the decoded response.
+func BingResults(query string, opts BingOptions, conf *Config) (*BingAnswer, error) {
+	params := make(url.Values)
+	params.Set("q", query)
+	opts.encode(params, conf)
+
+	var results BingAnswer
+	if err := bingQuery("/search", params, conf, &results); err != nil {
+		return nil, err
+	}
+
+	return &results, nil
+}
+
+// Bing performs a query and returns a formatted result. The top result is
+// picked by Bing's ranking. When Bing corrected the spelling of the query the
+// correction is shown, and with bing_related set related searches are
+// appended.
+func Bing(query string, conf *Config) (output string, err error) {
+	opts := BingOptions{Count: 1}
+	if len(conf.BingSafeSearch) == 0 {
+		opts.SafeSearch = "Moderate"
+	}
+
+	results, err := BingResults(query, opts, conf)
+	if err != nil {
+		return bingErrOutput("Bing", err)
+	}
+
+	output = formatBingAnswer(results)
+	if len(output) == 0 {
+		return "\x02Bing: No results found.\x02", nil
+	}
+
+	if conf.BingRelated && len(results.RelatedSearches.Value) > 0 {
+		var related []string
+		for i, r := range results.RelatedSearches.Value {
+			if i == 3 {
+				break
+			}
+			related = append(related, r.Text)
+		}
+		output += " \x02related:\x02 " + strings.Join(related, ", ")
+	}
+
+	return output, nil
+}
+
+// formatBingAnswer formats the highest ranked result, falling back to web
+// pages and then videos when the response has no ranking.
+func formatBingAnswer(results *BingAnswer) string {
+	var altered string
+	if len(results.QueryContext.AlteredQuery) != 0 {
+		altered = fmt.Sprintf("Showing results for \x02%s\x02: ", results.QueryContext.AlteredQuery)
+	}
 
+	ranked := results.Ranked()
+	if len(ranked) == 0 {
+		switch {
+		case len(results.WebPages.Value) > 0:
+			ranked = append(ranked, BingRankedResult{WebPage: &results.WebPages.Value[0]})
+		case len(results.Videos.Value) > 0:
+			ranked = append(ranked, BingRankedResult{Video: &results.Videos.Value[0]})
+		default:
+			return ""
+		}
+	}
+
+	top := ranked[0]
 	switch {
-	case len(results.WebPages.Value) > 0:
-		output := fmt.Sprintf("\x02Bing (\x02%d results\x02):\x02 %s - %s",
+	case top.WebPage != nil:
+		return fmt.Sprintf("\x02Bing (\x02%d results\x02):\x02 %s%s - %s",
 			results.WebPages.TotalEstimatedMatches,
-			results.WebPages.Value[0].URL,
-			results.WebPages.Value[0].Snippet)
-		return output, nil
-	case len(results.Videos.Value) > 0:
-		duration := strings.ToLower(strings.TrimPrefix(results.Videos.Value[0].Duration, "PT"))
-
-		output := fmt.Sprintf("\x02Bing (\x02%s\x02):\x02 %s - %s - %s",
-			duration,
-			results.Videos.Value[0].ContentURL,
-			results.Videos.Value[0].Name,
-			results.Videos.Value[0].Description)
-		return output, nil
-	default:
-		return "\x02Bing: No results found.\x02", nil
+			altered,
+			top.WebPage.URL,
+			top.WebPage.Snippet)
+	case top.News != nil:
+		return fmt.Sprintf("\x02Bing (\x02news\x02):\x02 %s%s - %s",
+			altered,
+			top.News.Name,
+			top.News.URL)
+	case top.Video != nil:
+		return formatBingVideo("Bing", altered, *top.Video)
+	case top.Computation != nil:
+		return fmt.Sprintf("\x02Bing:\x02 %s%s \x02=>\x02 %s",
+			altered,
+			top.Computation.Expression,
+			top.Computation.Value)
+	case top.TimeZone != nil:
+		return fmt.Sprintf("\x02Bing:\x02 %s%s \x02=>\x02 %s (%s)",
+			altered,
+			top.TimeZone.PrimaryCityTime.Location,
+			top.TimeZone.PrimaryCityTime.Time,
+			top.TimeZone.PrimaryCityTime.UTCOffset)
 	}
+
+	return ""
 }
@@ -0,0 +1,116 @@
+package query
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBingRanked(t *testing.T) {
+	t.Parallel()
+
+	js := `{
+		"webPages": {"totalEstimatedMatches": 20, "value": [{"url": "http://a"}, {"url": "http://b"}]},
+		"videos": {"value": [{"name": "v1"}, {"name": "v2"}]},
+		"computation": {"expression": "2+2", "value": "4"},
+		"rankingResponse": {"mainline": {"items": [
+			{"answerType": "Computation"},
+			{"answerType": "WebPages", "resultIndex": 1},
+			{"answerType": "Videos"},
+			{"answerType": "WebPages", "resultIndex": 0},
+			{"answerType": "SpellSuggestions"}
+		]}}
+	}`
+
+	var answer BingAnswer
+	if err := json.Unmarshal([]byte(js), &answer); err != nil {
+		t.Fatal(err)
+	}
+
+	ranked := answer.Ranked()
+	if len(ranked) != 5 {
+		t.Fatal("wrong number of results:", len(ranked))
+	}
+	if ranked[0].Computation == nil {
+		t.Error("computation should be first")
+	}
+	if ranked[1].WebPage == nil || ranked[1].WebPage.URL != "http://b" {
+		t.Error("second web page should be second")
+	}
+	if ranked[2].Video == nil || ranked[3].Video == nil || ranked[3].Video.Name != "v2" {
+		t.Error("whole video answer should be expanded")
+	}
+	if ranked[4].WebPage == nil || ranked[4].WebPage.URL != "http://a" {
+		t.Error("first web page should be last")
+	}
+
+	if out := formatBingAnswer(&answer); out != "\x02Bing:\x02 2+2 \x02=>\x02 4" {
+		t.Error("output was wrong:", out)
+	}
+
+	answer.QueryContext.AlteredQuery = "2 + 2"
+	if out := formatBingAnswer(&answer); out != "\x02Bing:\x02 Showing results for \x022 + 2\x02: 2+2 \x02=>\x02 4" {
+		t.Error("altered query was not shown:", out)
+	}
+
+	answer.RankingResponse.Mainline.Items = answer.RankingResponse.Mainline.Items[2:3]
+	if out := formatBingAnswer(&answer); !strings.Contains(out, "Showing results for") {
+		t.Error("altered query was not shown for videos:", out)
+	}
+}
+
+// TestBingError swaps bingURI so it can't run in parallel.
+func TestBingError(t *testing.T) {
+	body := `{"_type": "ErrorResponse", "errors": [{"code": "InvalidRequest",
+		"subCode": "ParameterInvalidValue", "message": "Parameter has invalid value.",
+		"parameter": "mkt"}]}`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		if r.URL.Query().Get("q") != "empty" {
+			fmt.Fprint(w, body)
+		}
+	}))
+	defer srv.Close()
+
+	old := bingURI
+	bingURI = srv.URL + "%s?%s"
+	defer func() { bingURI = old }()
+
+	var logged bytes.Buffer
+	conf := &Config{BingAPIKey: "key", Logger: slog.New(slog.NewTextHandler(&logged, nil))}
+
+	_, err := BingResults("gopher", BingOptions{Market: "xx-XX"}, conf)
+	e, ok := err.(BingError)
+	if !ok {
+		t.Fatalf("want a BingError, got %T %v", err, err)
+	}
+	if e.StatusCode != http.StatusBadRequest || len(e.Errors) != 1 {
+		t.Fatalf("error was wrong: %#v", e)
+	}
+	if got := e.Errors[0]; got.Code != "InvalidRequest" || got.SubCode != "ParameterInvalidValue" || got.Parameter != "mkt" {
+		t.Errorf("error details were wrong: %#v", got)
+	}
+	if want := "bing: Parameter has invalid value. (400 InvalidRequest/ParameterInvalidValue, parameter mkt)"; e.Error() != want {
+		t.Errorf("want %q, got %q", want, e.Error())
+	}
+	if !strings.Contains(logged.String(), "subCode=ParameterInvalidValue") {
+		t.Errorf("error should be logged: %q", logged.String())
+	}
+
+	if out, err := bingErrOutput("Bing", err); err != nil || out != "\x02Bing: Query error Parameter has invalid value." {
+		t.Errorf("output was wrong: %q %v", out, err)
+	}
+
+	_, err = BingResults("empty", BingOptions{}, conf)
+	if e, ok := err.(StatusError); !ok || e.StatusCode != http.StatusBadRequest {
+		t.Errorf("an empty error body should be a StatusError, got %T %v", err, err)
+	}
+	if out, _ := bingErrOutput("Bing", err); out != "\x02Bing: Query returned 400" {
+		t.Errorf("output was wrong: %q", out)
+	}
+}
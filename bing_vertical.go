@@ -0,0 +1,300 @@
+package query
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// BingNewsArticle is a news article from the Bing News Search API.
+type BingNewsArticle struct {
+	Name          string `json:"name"`
+	URL           string `json:"url"`
+	Description   string `json:"description"`
+	DatePublished string `json:"datePublished"`
+	Category      string `json:"category"`
+	Provider      []struct {
+		Name string `json:"name"`
+	} `json:"provider"`
+	Image struct {
+		Thumbnail struct {
+			ContentURL string `json:"contentUrl"`
+			Width      int    `json:"width"`
+			Height     int    `json:"height"`
+		} `json:"thumbnail"`
+	} `json:"image"`
+}
+
+// BingNewsAnswer is the response from the Bing News Search API.
+type BingNewsAnswer struct {
+	Type                  string            `json:"_type"`
+	TotalEstimatedMatches int               `json:"totalEstimatedMatches"`
+	Value                 []BingNewsArticle `json:"value"`
+}
+
+// BingImage is an image from the Bing Image Search API.
+type BingImage struct {
+	Name           string `json:"name"`
+	ContentURL     string `json:"contentUrl"`
+	HostPageURL    string `json:"hostPageUrl"`
+	ThumbnailURL   string `json:"thumbnailUrl"`
+	EncodingFormat string `json:"encodingFormat"`
+	ContentSize    string `json:"contentSize"`
+	Width          int    `json:"width"`
+	Height         int    `json:"height"`
+}
+
+// BingImagesAnswer is the response from the Bing Image Search API.
+type BingImagesAnswer struct {
+	Type                  string      `json:"_type"`
+	TotalEstimatedMatches int         `json:"totalEstimatedMatches"`
+	Value                 []BingImage `json:"value"`
+}
+
+// BingVideo is a video from the Bing Video Search API.
+type BingVideo struct {
+	ID            string `json:"id"`
+	Name          string `json:"name"`
+	Description   string `json:"description"`
+	ContentURL    string `json:"contentUrl"`
+	HostPageURL   string `json:"hostPageUrl"`
+	ThumbnailURL  string `json:"thumbnailUrl"`
+	Duration      string `json:"duration"`
+	DatePublished string `json:"datePublished"`
+	ViewCount     int    `json:"viewCount"`
+	Publisher     []struct {
+		Name string `json:"name"`
+	} `json:"publisher"`
+}
+
+// BingVideosAnswer is the response from the Bing Video Search API.
+type BingVideosAnswer struct {
+	Type                  string      `json:"_type"`
+	TotalEstimatedMatches int         `json:"totalEstimatedMatches"`
+	Value                 []BingVideo `json:"value"`
+}
+
+// BingEntity is a person, place or thing from the Bing Entity Search API.
+type BingEntity struct {
+	ID                     string `json:"id"`
+	Name                   string `json:"name"`
+	Description            string `json:"description"`
+	URL                    string `json:"url"`
+	WebSearchURL           string `json:"webSearchUrl"`
+	EntityPresentationInfo struct {
+		EntityScenario  string   `json:"entityScenario"`
+		EntityTypeHints []string `json:"entityTypeHints"`
+	} `json:"entityPresentationInfo"`
+}
+
+// BingPlace is a local business or location from the Bing Entity Search API.
+type BingPlace struct {
+	Name      string `json:"name"`
+	URL       string `json:"url"`
+	Telephone string `json:"telephone"`
+	Address   struct {
+		AddressLocality string `json:"addressLocality"`
+		AddressRegion   string `json:"addressRegion"`
+		PostalCode      string `json:"postalCode"`
+		Country         string `json:"addressCountry"`
+		Text            string `json:"text"`
+	} `json:"address"`
+}
+
+// BingEntitiesAnswer is the response from the Bing Entity Search API.
+type BingEntitiesAnswer struct {
+	Type     string `json:"_type"`
+	Entities struct {
+		Value []BingEntity `json:"value"`
+	} `json:"entities"`
+	Places struct {
+		Value []BingPlace `json:"value"`
+	} `json:"places"`
+}
+
+// BingNewsOptions narrows down a news search.
+type BingNewsOptions struct {
+	BingOptions
+
+	// Category is a news category such as Business or Sports. Bing only
+	// honours it when the query is empty, the top articles of the category
+	// are then returned. It is not sent with a query.
+	Category string
+}
+
+// BingNewsResults searches Bing News and returns the decoded response.
+func BingNewsResults(query string, opts BingNewsOptions, conf *Config) (*BingNewsAnswer, error) {
+	params := make(url.Values)
+	endpoint := "/news/search"
+	if len(query) == 0 {
+		endpoint = "/news"
+		setNonEmpty(params, "category", opts.Category)
+	} else {
+		params.Set("q", query)
+	}
+	opts.encode(params, conf)
+
+	var results BingNewsAnswer
+	if err := bingQuery(endpoint, params, conf, &results); err != nil {
+		return nil, err
+	}
+
+	return &results, nil
+}
+
+// BingImagesResults searches Bing Images and returns the decoded response.
+func BingImagesResults(query string, opts BingOptions, conf *Config) (*BingImagesAnswer, error) {
+	params := make(url.Values)
+	params.Set("q", query)
+	opts.encode(params, conf)
+
+	var results BingImagesAnswer
+	if err := bingQuery("/images/search", params, conf, &results); err != nil {
+		return nil, err
+	}
+
+	return &results, nil
+}
+
+// BingVideosResults searches Bing Videos and returns the decoded response.
+func BingVideosResults(query string, opts BingOptions, conf *Config) (*BingVideosAnswer, error) {
+	params := make(url.Values)
+	params.Set("q", query)
+	opts.encode(params, conf)
+
+	var results BingVideosAnswer
+	if err := bingQuery("/videos/search", params, conf, &results); err != nil {
+		return nil, err
+	}
+
+	return &results, nil
+}
+
+// BingEntitiesResults looks up entities and places with Bing and returns the
+// decoded response.
+func BingEntitiesResults(query string, opts BingOptions, conf *Config) (*BingEntitiesAnswer, error) {
+	params := make(url.Values)
+	params.Set("q", query)
+	params.Set("mkt", "en-US")
+	opts.encode(params, conf)
+
+	var results BingEntitiesAnswer
+	if err := bingQuery("/entities", params, conf, &results); err != nil {
+		return nil, err
+	}
+
+	return &results, nil
+}
+
+// BingNews searches for news and returns a formatted result.
+func BingNews(query string, conf *Config) (output string, err error) {
+	results, err := BingNewsResults(query, BingNewsOptions{BingOptions: BingOptions{Count: 1}}, conf)
+	if err != nil {
+		return bingErrOutput("Bing News", err)
+	}
+
+	if len(results.Value) == 0 {
+		return "\x02Bing News: No results found.\x02", nil
+	}
+
+	article := results.Value[0]
+	provider := "unknown"
+	if len(article.Provider) > 0 {
+		provider = article.Provider[0].Name
+	}
+
+	output = fmt.Sprintf("\x02Bing News (\x02%s\x02):\x02 %s - %s",
+		provider,
+		article.Name,
+		article.URL,
+	)
+
+	return output, nil
+}
+
+// BingImages searches for images and returns a formatted result.
+func BingImages(query string, conf *Config) (output string, err error) {
+	results, err := BingImagesResults(query, BingOptions{Count: 1}, conf)
+	if err != nil {
+		return bingErrOutput("Bing Images", err)
+	}
+
+	if len(results.Value) == 0 {
+		return "\x02Bing Images: No results found.\x02", nil
+	}
+
+	image := results.Value[0]
+	output = fmt.Sprintf("\x02Bing Images (\x02%dx%d\x02):\x02 %s - %s (%s)",
+		image.Width,
+		image.Height,
+		image.ContentURL,
+		image.Name,
+		image.HostPageURL,
+	)
+
+	return output, nil
+}
+
+// BingVideos searches for videos and returns a formatted result.
+func BingVideos(query string, conf *Config) (output string, err error) {
+	results, err := BingVideosResults(query, BingOptions{Count: 1}, conf)
+	if err != nil {
+		return bingErrOutput("Bing Videos", err)
+	}
+
+	if len(results.Value) == 0 {
+		return "\x02Bing Videos: No results found.\x02", nil
+	}
+
+	return formatBingVideo("Bing Videos", "", results.Value[0]), nil
+}
+
+// BingEntities looks up a person, place or thing and returns a formatted
+// result.
+func BingEntities(query string, conf *Config) (output string, err error) {
+	results, err := BingEntitiesResults(query, BingOptions{}, conf)
+	if err != nil {
+		return bingErrOutput("Bing", err)
+	}
+
+	switch {
+	case len(results.Entities.Value) > 0:
+		entity := results.Entities.Value[0]
+		link := entity.URL
+		if len(link) == 0 {
+			link = entity.WebSearchURL
+		}
+
+		output = fmt.Sprintf("\x02Bing (\x02%s\x02):\x02 %s - %s",
+			entity.Name,
+			entity.Description,
+			link,
+		)
+	case len(results.Places.Value) > 0:
+		place := results.Places.Value[0]
+		output = fmt.Sprintf("\x02Bing (\x02%s\x02):\x02 %s",
+			place.Name,
+			place.Address.Text,
+		)
+		if len(place.Telephone) != 0 {
+			output += " - " + place.Telephone
+		}
+	default:
+		output = "\x02Bing: No results found.\x02"
+	}
+
+	return output, nil
+}
+
+func formatBingVideo(name, altered string, video BingVideo) string {
+	duration := strings.ToLower(strings.TrimPrefix(video.Duration, "PT"))
+
+	return fmt.Sprintf("\x02%s (\x02%s\x02):\x02 %s%s - %s - %s",
+		name,
+		duration,
+		altered,
+		video.ContentURL,
+		video.Name,
+		video.Description,
+	)
+}
@@ -0,0 +1,137 @@
+package query
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// bingTestServer answers Bing requests by path with the bodies in routes,
+// paths missing from routes fail with an empty 500. bingURI is swapped so
+// tests using it can't run in parallel.
+func bingTestServer(t *testing.T, routes map[string]string) *[]*http.Request {
+	var requests []*http.Request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r)
+		if r.Header.Get("Ocp-Apim-Subscription-Key") != "key" {
+			t.Error("api key was not sent")
+		}
+
+		body, ok := routes[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(w, body)
+	}))
+
+	old := bingURI
+	bingURI = srv.URL + "%s?%s"
+	t.Cleanup(func() {
+		bingURI = old
+		srv.Close()
+	})
+
+	return &requests
+}
+
+func TestBingVerticals(t *testing.T) {
+	requests := bingTestServer(t, map[string]string{
+		"/news/search": `{"_type": "News", "value": [{"name": "Go 2 released", "url": "http://n",
+			"provider": [{"name": "Gopher Times"}]}]}`,
+		"/news":          `{"_type": "News", "value": []}`,
+		"/images/search": `{"_type": "Images", "value": [{"name": "Gopher", "contentUrl": "http://i.png", "hostPageUrl": "http://h", "width": 640, "height": 480}]}`,
+		"/entities": `{"_type": "SearchResponse", "places": {"value": [{"name": "Gopher Cafe",
+			"telephone": "555-1234", "address": {"text": "1 Go Street"}}]}}`,
+	})
+	conf := &Config{BingAPIKey: "key", BingMarket: "nb-NO"}
+
+	tests := []struct {
+		Name   string
+		Fn     func(string, *Config) (string, error)
+		Query  string
+		Path   string
+		Params url.Values
+		Out    string
+	}{
+		{
+			"news", BingNews, "go", "/news/search",
+			url.Values{"q": {"go"}, "count": {"1"}, "mkt": {"nb-NO"}},
+			"\x02Bing News (\x02Gopher Times\x02):\x02 Go 2 released - http://n",
+		},
+		{
+			"top news", BingNews, "", "/news",
+			url.Values{"count": {"1"}, "mkt": {"nb-NO"}},
+			"\x02Bing News: No results found.\x02",
+		},
+		{
+			"images", BingImages, "gopher", "/images/search",
+			url.Values{"q": {"gopher"}, "count": {"1"}, "mkt": {"nb-NO"}},
+			"\x02Bing Images (\x02640x480\x02):\x02 http://i.png - Gopher (http://h)",
+		},
+		{
+			"videos", BingVideos, "gopher", "/videos/search",
+			url.Values{"q": {"gopher"}, "count": {"1"}, "mkt": {"nb-NO"}},
+			"\x02Bing Videos: Query returned 500",
+		},
+		{
+			"entities", BingEntities, "gopher cafe", "/entities",
+			url.Values{"q": {"gopher cafe"}, "mkt": {"nb-NO"}},
+			"\x02Bing (\x02Gopher Cafe\x02):\x02 1 Go Street - 555-1234",
+		},
+	}
+
+	for _, test := range tests {
+		*requests = nil
+		out, err := test.Fn(test.Query, conf)
+		if err != nil {
+			t.Errorf("%s: %v", test.Name, err)
+			continue
+		}
+		if out != test.Out {
+			t.Errorf("%s: output was wrong: %q", test.Name, out)
+		}
+
+		if len(*requests) != 1 {
+			t.Errorf("%s: want one request, got %d", test.Name, len(*requests))
+			continue
+		}
+		r := (*requests)[0]
+		if r.URL.Path != test.Path {
+			t.Errorf("%s: want endpoint %s, got %s", test.Name, test.Path, r.URL.Path)
+		}
+		for key, want := range test.Params {
+			if got := r.URL.Query()[key]; fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Errorf("%s: %s: want %v, got %v", test.Name, key, want, got)
+			}
+		}
+	}
+}
+
+func TestBingNewsCategory(t *testing.T) {
+	requests := bingTestServer(t, map[string]string{
+		"/news":        `{"value": []}`,
+		"/news/search": `{"value": []}`,
+	})
+	conf := &Config{BingAPIKey: "key"}
+	opts := BingNewsOptions{Category: "Sports"}
+
+	if _, err := BingNewsResults("", opts, conf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := BingNewsResults("football", opts, conf); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(*requests) != 2 {
+		t.Fatal("want two requests, got", len(*requests))
+	}
+	if c := (*requests)[0].URL.Query().Get("category"); c != "Sports" {
+		t.Errorf("category should be sent for top news, got %q", c)
+	}
+	if _, ok := (*requests)[1].URL.Query()["category"]; ok {
+		t.Error("category should not be sent with a query")
+	}
+}
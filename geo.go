@@ -4,28 +4,308 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
 )
 
 const (
-	geoErrMsg = "Unable to find %s"
-	geoURI    = "http://api.geonames.org/search?username=%s&q=%s&maxRows=1&type=json&orderby=relevance"
+	geoErrMsg    = "Unable to find %s"
+	geoURI       = "http://api.geonames.org/search?%s"
+	geoNearbyURI = "http://api.geonames.org/findNearbyPlaceNameJSON?%s"
 )
 
-var placesLookup = map[string][]string{
-	"oslo":     {"Norway", "Oslo", "Oslo", "Oslo"},
-	"sandvika": {"Norway", "Akershus", "Bærum", "Sandvika"},
+const (
+	// geoCandidates is how many places are asked for when looking up a
+	// place for a query.
+	geoCandidates = 10
+	// geoFuzzy is the fuzziness used to retry queries that found nothing,
+	// 1 is an exact match.
+	geoFuzzy = 0.8
+	// geoAmbiguousRatio is how large the population of another place with
+	// the same name has to be compared to the best match before the query
+	// is considered ambiguous.
+	geoAmbiguousRatio = 0.05
+	// geoMaxSuggestions limits how many places are suggested when a query
+	// is ambiguous.
+	geoMaxSuggestions = 3
+)
+
+// placesLookup are built in overrides, the places and places_file config
+// take precedence over them.
+var placesLookup = map[string]Place{
+	"oslo":     {Name: "Oslo", Admin1: "Oslo", Country: "Norway", CountryCode: "NO", TimeZone: "Europe/Oslo", Lat: 59.91273, Lng: 10.74609},
+	"sandvika": {Name: "Sandvika", Admin1: "Akershus", Country: "Norway", CountryCode: "NO", TimeZone: "Europe/Oslo", Lat: 59.88917, Lng: 10.52306},
+}
+
+// maxAliasDepth limits how many aliases are followed, aliases pointing at
+// each other are an error.
+const maxAliasDepth = 8
+
+// PlaceOverride pins a place name to a location. Either Lat and Lng are
+// set, or Alias names the place to look up instead, for example
+// "Portland, Oregon" or another override.
+type PlaceOverride struct {
+	Alias string `toml:"alias"`
+
+	Name    string  `toml:"name"`
+	Admin1  string  `toml:"admin1"`
+	Country string  `toml:"country"`
+	Lat     float64 `toml:"lat"`
+	Lng     float64 `toml:"lng"`
+}
+
+// validate checks that the override either aliases a place or has a
+// location, without either it would put the place at 0,0.
+func (p PlaceOverride) validate(name string) error {
+	if len(p.Alias) == 0 && p.Lat == 0 && p.Lng == 0 {
+		return fmt.Errorf("place %q needs either an alias or lat and lng", name)
+	}
+	return nil
+}
+
+// validatePlaces checks every place override in the config and lower-cases
+// their names, see placeOverride.
+func (c *Config) validatePlaces() error {
+	for name, place := range c.Places {
+		if err := place.validate(name); err != nil {
+			return err
+		}
+	}
+	c.Places = lowerPlaces(c.Places)
+	return nil
+}
+
+// lowerPlaces lower-cases the names of place overrides.
+func lowerPlaces(places map[string]PlaceOverride) map[string]PlaceOverride {
+	lower := make(map[string]PlaceOverride, len(places))
+	for name, place := range places {
+		lower[strings.ToLower(name)] = place
+	}
+	return lower
+}
+
+// LoadPlaces reads place overrides from a toml file where every table is
+// an override named by its key. Overrides already in the config win, names
+// are compared ignoring case.
+func (c *Config) LoadPlaces(file string) error {
+	var places map[string]PlaceOverride
+	if _, err := toml.DecodeFile(file, &places); err != nil {
+		return err
+	}
+	for name, place := range places {
+		if err := place.validate(name); err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+	}
+
+	c.Places = lowerPlaces(c.Places)
+	for name, place := range lowerPlaces(places) {
+		if _, ok := c.Places[name]; !ok {
+			c.Places[name] = place
+		}
+	}
+
+	return nil
+}
+
+// placeOverride finds the override of a name in the config, ignoring case.
+// The names are lower-cased when the config and places file are loaded.
+func (c *Config) placeOverride(name string) (PlaceOverride, bool) {
+	place, ok := c.Places[strings.ToLower(name)]
+	return place, ok
+}
+
+// resolvePlace finds a place by name, checking the overrides in the config
+// and the built in ones before asking geonames.
+func resolvePlace(query string, conf *Config) (Place, error) {
+	for depth := 0; ; depth++ {
+		override, ok := conf.placeOverride(strings.TrimSpace(query))
+		if !ok {
+			break
+		}
+		if depth == maxAliasDepth {
+			return Place{}, fmt.Errorf("place %q is in an alias loop", query)
+		}
+
+		if len(override.Alias) == 0 {
+			if err := override.validate(query); err != nil {
+				return Place{}, err
+			}
+
+			name := override.Name
+			if len(name) == 0 {
+				name = query
+			}
+			return Place{
+				Name:    name,
+				Admin1:  override.Admin1,
+				Country: override.Country,
+				Lat:     override.Lat,
+				Lng:     override.Lng,
+			}, nil
+		}
+
+		query = override.Alias
+	}
+
+	if place, ok := placesLookup[strings.ToLower(query)]; ok {
+		return place, nil
+	}
+
+	return Locate(query, conf)
+}
+
+// Place is a named location on earth. Places found with geonames also carry
+// their codes, population, feature and time zone, overrides may not.
+type Place struct {
+	Name    string
+	Admin1  string
+	Country string
+	Lat     float64
+	Lng     float64
+
+	GeonameID    int
+	CountryCode  string
+	AdminCode1   string
+	Population   int
+	FeatureClass string
+	FeatureCode  string
+	TimeZone     string
+}
+
+// displayName names the place and its country for output, places such as
+// coordinates and overrides may not know their country.
+func (p Place) displayName() string {
+	if len(p.Country) == 0 {
+		return p.Name
+	}
+	return p.Name + ", " + p.Country
+}
+
+// location is the time zone of the place, approximated from its longitude
+// when it has no known time zone.
+func (p Place) location() *time.Location {
+	if len(p.TimeZone) != 0 {
+		if loc, err := time.LoadLocation(p.TimeZone); err == nil {
+			return loc
+		}
+	}
+
+	offset := int(math.Round(p.Lng/15)) * 3600
+	return time.FixedZone("", offset)
+}
+
+// qualifier is the shortest code that tells the place apart from others
+// with the same name: the state for places in the US, otherwise the
+// country.
+func (p Place) qualifier() string {
+	if p.CountryCode == "US" && len(p.AdminCode1) != 0 {
+		return p.AdminCode1
+	}
+	if len(p.CountryCode) != 0 {
+		return p.CountryCode
+	}
+	return p.Country
+}
+
+// matches checks if a qualifier like "FR", "TX" or "Oregon" given after the
+// name of a place refers to this place.
+func (p Place) matches(qualifier string) bool {
+	for _, s := range []string{p.CountryCode, p.AdminCode1, p.Country, p.Admin1} {
+		if len(s) != 0 && strings.EqualFold(s, qualifier) {
+			return true
+		}
+	}
+	return false
+}
+
+// GeocodeOptions narrows down a geonames search.
+type GeocodeOptions struct {
+	// MaxRows is how many places to return, geonames defaults to 100.
+	MaxRows int
+	// Country only returns places in the countries with these ISO codes.
+	Country []string
+	// CountryBias ranks places in the country with this ISO code first.
+	CountryBias string
+	// FeatureClass only returns places of these feature classes, for
+	// example "P" for cities and villages.
+	FeatureClass []string
+	// Fuzzy allows misspelled names, from 0 to 1 where 1 is an exact match.
+	Fuzzy float64
+	// Language is the ISO code of the language to name places in.
+	Language string
+}
+
+func (o GeocodeOptions) encode(params url.Values) {
+	if o.MaxRows > 0 {
+		params.Set("maxRows", strconv.Itoa(o.MaxRows))
+	}
+	for _, c := range o.Country {
+		params.Add("country", c)
+	}
+	setNonEmpty(params, "countryBias", o.CountryBias)
+	for _, f := range o.FeatureClass {
+		params.Add("featureClass", f)
+	}
+	if o.Fuzzy > 0 && o.Fuzzy < 1 {
+		params.Set("fuzzy", strconv.FormatFloat(o.Fuzzy, 'f', -1, 64))
+	}
+	setNonEmpty(params, "lang", o.Language)
 }
 
 type geonameplace struct {
-	CountryName string
-	AdminName1  string
-	Name        string
+	GeonameID   int    `json:"geonameId"`
+	Name        string `json:"name"`
+	CountryName string `json:"countryName"`
+	CountryCode string `json:"countryCode"`
+	AdminName1  string `json:"adminName1"`
+	AdminCode1  string `json:"adminCode1"`
+	Lat         string `json:"lat"`
+	Lng         string `json:"lng"`
+	Population  int    `json:"population"`
+	Fcl         string `json:"fcl"`
+	Fcode       string `json:"fcode"`
+	Timezone    struct {
+		TimeZoneID string `json:"timeZoneId"`
+	} `json:"timezone"`
+}
+
+func (g geonameplace) place() (place Place, err error) {
+	place = Place{
+		Name:         g.Name,
+		Admin1:       g.AdminName1,
+		Country:      g.CountryName,
+		GeonameID:    g.GeonameID,
+		CountryCode:  g.CountryCode,
+		AdminCode1:   g.AdminCode1,
+		Population:   g.Population,
+		FeatureClass: g.Fcl,
+		FeatureCode:  g.Fcode,
+		TimeZone:     g.Timezone.TimeZoneID,
+	}
+
+	if place.Lat, err = strconv.ParseFloat(g.Lat, 64); err != nil {
+		return place, fmt.Errorf("geonames returned a bad latitude %q: %w", g.Lat, err)
+	}
+	if place.Lng, err = strconv.ParseFloat(g.Lng, 64); err != nil {
+		return place, fmt.Errorf("geonames returned a bad longitude %q: %w", g.Lng, err)
+	}
+
+	return place, nil
 }
 
 type geonamesdata struct {
 	Geonames []geonameplace
+	Status   *struct {
+		Message string
+		Value   int
+	}
 }
 
 type geoErr struct {
@@ -36,36 +316,309 @@ func (g geoErr) Error() string {
 	return fmt.Sprintf(geoErrMsg, g.query)
 }
 
-func getLocation(query string, conf *Config) (country, state, city string, err error) {
+// AmbiguousPlaceError is returned when a query matches several places that
+// are too alike to pick one, the query should be qualified with one of the
+// candidates.
+type AmbiguousPlaceError struct {
+	Query      string
+	Candidates []Place
+}
+
+func (a AmbiguousPlaceError) Error() string {
+	names := make([]string, len(a.Candidates))
+	for i, c := range a.Candidates {
+		names[i] = fmt.Sprintf("%s, %s", c.Name, c.qualifier())
+	}
+
+	if len(names) == 1 {
+		return fmt.Sprintf("Did you mean %s?", names[0])
+	}
+	return fmt.Sprintf("Did you mean %s or %s?",
+		strings.Join(names[:len(names)-1], ", "), names[len(names)-1])
+}
+
+// Geocode searches geonames for places matching the query, best match first.
+func Geocode(query string, opts GeocodeOptions, conf *Config) ([]Place, error) {
 	if len(conf.GeonamesID) == 0 {
-		return country, state, city, errors.New("geo cannot be used without geonames_id in config")
+		return nil, errors.New("geo cannot be used without geonames_id in config")
 	}
 
-	resp, err := http.Get(fmt.Sprintf(geoURI, conf.GeonamesID, url.QueryEscape(query)))
+	params := url.Values{}
+	params.Set("username", conf.GeonamesID)
+	params.Set("q", query)
+	params.Set("type", "json")
+	params.Set("orderby", "relevance")
+	params.Set("style", "FULL")
+	opts.encode(params)
 
+	resp, err := http.Get(fmt.Sprintf(geoURI, params.Encode()))
 	if err != nil {
-		return
+		return nil, err
 	}
-
 	defer resp.Body.Close()
 
-	r := json.NewDecoder(resp.Body)
+	conf.log().Debug("geonames query", "query", query, "status", resp.StatusCode)
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, StatusError{Service: "geonames", StatusCode: resp.StatusCode}
+	}
 
 	var data geonamesdata
-	err = r.Decode(&data)
+	if err = json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return nil, err
+	}
+	if data.Status != nil {
+		return nil, fmt.Errorf("geonames error %d: %s", data.Status.Value, data.Status.Message)
+	}
+
+	places := make([]Place, 0, len(data.Geonames))
+	for _, g := range data.Geonames {
+		place, err := g.place()
+		if err != nil {
+			return nil, err
+		}
+		places = append(places, place)
+	}
+
+	return places, nil
+}
+
+// getLocation finds the place a query most likely means. A query may be
+// qualified with a country or state after a comma, like "Paris, TX".
+// Queries matching several places of similar size fail with an
+// AmbiguousPlaceError. The offline geocoder in the config is asked before
+// or after geonames depending on GeonamesOffline.
+func getLocation(query string, conf *Config) (Place, error) {
+	if conf.Geocoder == nil {
+		return geocodeOnline(query, conf)
+	}
+
+	if conf.GeonamesOffline == OfflineFallback {
+		place, err := geocodeOnline(query, conf)
+		if !geoFailed(err) {
+			return place, err
+		}
+
+		conf.log().Debug("geonames failed, using the offline geocoder", "query", query, "err", err)
+		if place, offErr := conf.Geocoder.locate(query); !geoFailed(offErr) {
+			return place, offErr
+		}
+		return place, err
+	}
+
+	place, err := conf.Geocoder.locate(query)
+	if !geoFailed(err) || len(conf.GeonamesID) == 0 {
+		return place, err
+	}
+	return geocodeOnline(query, conf)
+}
+
+// geoFailed checks if another geocoder should be asked after an error,
+// ambiguous places were found and should not be second guessed.
+func geoFailed(err error) bool {
+	if err == nil {
+		return false
+	}
+	_, ambiguous := err.(AmbiguousPlaceError)
+	return !ambiguous
+}
+
+// geocodeOnline finds the place a query most likely means with geonames,
+// retrying misspelled queries fuzzily.
+func geocodeOnline(query string, conf *Config) (Place, error) {
+	name, qualifier := splitPlaceQualifier(query)
 
+	opts := GeocodeOptions{MaxRows: geoCandidates}
+	candidates, err := Geocode(name, opts, conf)
 	if err != nil {
-		return
+		return Place{}, err
+	}
+	if len(candidates) == 0 {
+		opts.Fuzzy = geoFuzzy
+		if candidates, err = Geocode(name, opts, conf); err != nil {
+			return Place{}, err
+		}
+	}
+	if len(candidates) == 0 {
+		return Place{}, geoErr{query}
+	}
+
+	if len(qualifier) != 0 {
+		if place, ok := qualifiedPlace(qualifier, candidates); ok {
+			return place, nil
+		}
+
+		// The qualifier may be part of the name, "Washington, D.C.", so
+		// let geonames make sense of the whole query.
+		opts.Fuzzy = 0
+		if candidates, err = Geocode(query, opts, conf); err != nil {
+			return Place{}, err
+		}
+		if len(candidates) == 0 {
+			return Place{}, geoErr{query}
+		}
+		return candidates[0], nil
 	}
 
+	return pickPlace(name, candidates)
+}
+
+// qualifiedPlace finds the first of the candidates the qualifier refers to.
+func qualifiedPlace(qualifier string, candidates []Place) (Place, bool) {
+	for _, c := range candidates {
+		if c.matches(qualifier) {
+			return c, true
+		}
+	}
+	return Place{}, false
+}
+
+// splitPlaceQualifier splits "Paris, TX" into the name and the qualifier
+// after the last comma.
+func splitPlaceQualifier(query string) (name, qualifier string) {
+	query = strings.TrimSpace(query)
+	i := strings.LastIndexByte(query, ',')
+	if i < 0 {
+		return query, ""
+	}
+	return strings.TrimSpace(query[:i]), strings.TrimSpace(query[i+1:])
+}
+
+// pickPlace picks the best of the candidates for a query, unless other
+// candidates share its name and are large enough to be what was meant.
+func pickPlace(query string, candidates []Place) (Place, error) {
+	best := candidates[0]
+	if !strings.EqualFold(best.Name, query) {
+		return best, nil
+	}
+
+	alike := []Place{best}
+	seen := map[string]bool{best.qualifier(): true}
+	for _, c := range candidates[1:] {
+		if len(alike) == geoMaxSuggestions {
+			break
+		}
+		if !strings.EqualFold(c.Name, query) || seen[c.qualifier()] {
+			continue
+		}
+		if float64(c.Population) < float64(best.Population)*geoAmbiguousRatio {
+			continue
+		}
+
+		seen[c.qualifier()] = true
+		alike = append(alike, c)
+	}
+
+	if len(alike) == 1 {
+		return best, nil
+	}
+	return Place{}, AmbiguousPlaceError{Query: query, Candidates: alike}
+}
+
+// ReverseGeocode finds the populated place nearest to a location.
+func ReverseGeocode(lat, lng float64, conf *Config) (Place, error) {
+	if len(conf.GeonamesID) == 0 {
+		return Place{}, errors.New("geo cannot be used without geonames_id in config")
+	}
+
+	params := url.Values{}
+	params.Set("username", conf.GeonamesID)
+	params.Set("lat", strconv.FormatFloat(lat, 'f', -1, 64))
+	params.Set("lng", strconv.FormatFloat(lng, 'f', -1, 64))
+	params.Set("style", "FULL")
+
+	resp, err := http.Get(fmt.Sprintf(geoNearbyURI, params.Encode()))
+	if err != nil {
+		return Place{}, err
+	}
+	defer resp.Body.Close()
+
+	conf.log().Debug("geonames reverse query", "lat", lat, "lng", lng, "status", resp.StatusCode)
+
+	if resp.StatusCode != http.StatusOK {
+		return Place{}, StatusError{Service: "geonames", StatusCode: resp.StatusCode}
+	}
+
+	var data geonamesdata
+	if err = json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return Place{}, err
+	}
+	if data.Status != nil {
+		return Place{}, fmt.Errorf("geonames error %d: %s", data.Status.Value, data.Status.Message)
+	}
 	if len(data.Geonames) == 0 {
-		err = geoErr{query}
-		return
+		return Place{}, geoErr{formatCoordinates(lat, lng)}
 	}
 
-	country = data.Geonames[0].CountryName
-	state = data.Geonames[0].AdminName1
-	city = data.Geonames[0].Name
+	return data.Geonames[0].place()
+}
+
+// Locate finds the place a query means. Besides names it understands
+// coordinates like "59.91,10.75", geohashes like "u4xsud" or "gh:90210" and
+// plus codes, either full like "9FFGWQ2G+XH" or short with a place to search
+// near like "WQ2G+XH Oslo". Locations are named after the nearest populated
+// place.
+func Locate(query string, conf *Config) (Place, error) {
+	query = strings.TrimSpace(query)
+
+	if lat, lng, ok := parseCoordinates(query); ok {
+		return nearestPlace(lat, lng, conf), nil
+	}
+
+	code, reference, _ := strings.Cut(query, " ")
+	if isPlusCode(code) {
+		if len(reference) == 0 {
+			if lat, lng, ok := decodePlusCode(code); ok {
+				return nearestPlace(lat, lng, conf), nil
+			}
+			return Place{}, geoErr{query}
+		}
+
+		ref, err := resolvePlace(reference, conf)
+		if err != nil {
+			return Place{}, err
+		}
+		lat, lng, _ := recoverPlusCode(code, ref.Lat, ref.Lng)
+		return nearestPlace(lat, lng, conf), nil
+	}
+
+	if lat, lng, ok := decodeGeohash(query); ok {
+		return nearestPlace(lat, lng, conf), nil
+	}
+
+	return getLocation(query, conf)
+}
+
+// nearestPlace is the location named after the nearest populated place, or
+// after its coordinates when it has none.
+func nearestPlace(lat, lng float64, conf *Config) Place {
+	var place Place
+	var found bool
+
+	offline := conf.Geocoder != nil
+	if offline && conf.GeonamesOffline != OfflineFallback {
+		place, found = conf.Geocoder.Nearest(lat, lng)
+	}
+	if !found && len(conf.GeonamesID) != 0 {
+		var err error
+		if place, err = ReverseGeocode(lat, lng, conf); err == nil {
+			found = true
+		} else {
+			conf.log().Debug("reverse geocoding failed", "lat", lat, "lng", lng, "err", err)
+		}
+	}
+	if !found && offline && conf.GeonamesOffline == OfflineFallback {
+		place, found = conf.Geocoder.Nearest(lat, lng)
+	}
+	if !found {
+		place = Place{Name: formatCoordinates(lat, lng)}
+	}
+
+	place.Lat, place.Lng = lat, lng
+	return place
+}
 
-	return
+// formatCoordinates formats a location to about 10 metres.
+func formatCoordinates(lat, lng float64) string {
+	return fmt.Sprintf("%.4f, %.4f", lat, lng)
 }
@@ -0,0 +1,224 @@
+package query
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// parseCoordinates reads a latitude and longitude in decimal degrees
+// separated by a comma or spaces, like "59.91,10.75" or "59.91 -10.75".
+func parseCoordinates(query string) (lat, lng float64, ok bool) {
+	fields := strings.FieldsFunc(query, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	})
+	if len(fields) != 2 {
+		return 0, 0, false
+	}
+
+	var err error
+	if lat, err = strconv.ParseFloat(fields[0], 64); err != nil {
+		return 0, 0, false
+	}
+	if lng, err = strconv.ParseFloat(fields[1], 64); err != nil {
+		return 0, 0, false
+	}
+	// Comparisons with NaN are always false, so check for it first.
+	if math.IsNaN(lat) || math.IsNaN(lng) || math.Abs(lat) > 90 || math.Abs(lng) > 180 {
+		return 0, 0, false
+	}
+
+	return lat, lng, true
+}
+
+const geohashAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// decodeGeohash finds the center of the cell of a geohash like "u4xsud".
+// Words made of geohash letters are common, and so are numbers like postal
+// codes, so only hashes of at least five characters with both a digit and a
+// letter in them are accepted unless they are prefixed with "gh:".
+func decodeGeohash(hash string) (lat, lng float64, ok bool) {
+	hash = strings.ToLower(hash)
+	if prefixed := strings.TrimPrefix(hash, "gh:"); prefixed != hash {
+		hash = prefixed
+	} else if len(hash) < 5 || !strings.ContainsAny(hash, "0123456789") ||
+		!strings.ContainsAny(hash, geohashAlphabet[10:]) {
+		return 0, 0, false
+	}
+	if len(hash) == 0 || len(hash) > 12 {
+		return 0, 0, false
+	}
+
+	latMin, latMax := -90.0, 90.0
+	lngMin, lngMax := -180.0, 180.0
+	even := true
+	for _, c := range hash {
+		idx := strings.IndexRune(geohashAlphabet, c)
+		if idx < 0 {
+			return 0, 0, false
+		}
+
+		for bit := 4; bit >= 0; bit-- {
+			set := idx&(1<<bit) != 0
+			if even {
+				mid := (lngMin + lngMax) / 2
+				if set {
+					lngMin = mid
+				} else {
+					lngMax = mid
+				}
+			} else {
+				mid := (latMin + latMax) / 2
+				if set {
+					latMin = mid
+				} else {
+					latMax = mid
+				}
+			}
+			even = !even
+		}
+	}
+
+	return (latMin + latMax) / 2, (lngMin + lngMax) / 2, true
+}
+
+const (
+	plusCodeAlphabet  = "23456789CFGHJMPQRVWX"
+	plusCodeSeparator = 8
+	plusCodePairs     = 10
+)
+
+// plusCodeResolutions are the sizes in degrees of the cells of each pair of
+// digits in a plus code.
+var plusCodeResolutions = [...]float64{20, 1, 0.05, 0.0025, 0.000125}
+
+// isPlusCode checks if a code looks like a full or short plus code, like
+// "9FFGWQ2G+XH" or "WQ2G+XH".
+func isPlusCode(code string) bool {
+	code = strings.ToUpper(code)
+	sep := strings.IndexByte(code, '+')
+	if sep < 0 || sep > plusCodeSeparator || sep%2 != 0 || strings.Count(code, "+") != 1 {
+		return false
+	}
+
+	digits := strings.TrimRight(code[:sep], "0")
+	padding := sep - len(digits)
+	if padding%2 != 0 || strings.Contains(digits, "0") {
+		return false
+	}
+	if padding > 0 && (sep != plusCodeSeparator || len(code) != sep+1) {
+		return false
+	}
+	if len(code) == sep+2 || (sep < plusCodeSeparator && (sep < 4 || len(code) < sep+3)) {
+		return false
+	}
+
+	for _, c := range digits + code[sep+1:] {
+		if !strings.ContainsRune(plusCodeAlphabet, c) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isFullPlusCode checks if a plus code can be decoded without a reference
+// location.
+func isFullPlusCode(code string) bool {
+	return isPlusCode(code) && strings.IndexByte(code, '+') == plusCodeSeparator
+}
+
+// decodePlusCode finds the center of the area of a full plus code.
+func decodePlusCode(code string) (lat, lng float64, ok bool) {
+	if !isFullPlusCode(code) {
+		return 0, 0, false
+	}
+
+	code = strings.ToUpper(strings.Replace(code, "+", "", 1))
+	code = strings.TrimRight(code, "0")
+
+	lat, lng = -90, -180
+	var latRes, lngRes float64
+	for i := 0; i < len(code) && i < plusCodePairs; i += 2 {
+		latRes = plusCodeResolutions[i/2]
+		lngRes = latRes
+		lat += float64(strings.IndexByte(plusCodeAlphabet, code[i])) * latRes
+		if i+1 < len(code) {
+			lng += float64(strings.IndexByte(plusCodeAlphabet, code[i+1])) * lngRes
+		}
+	}
+
+	// Digits after the pairs split the cell into 4 columns and 5 rows.
+	for i := plusCodePairs; i < len(code); i++ {
+		idx := strings.IndexByte(plusCodeAlphabet, code[i])
+		latRes /= 5
+		lngRes /= 4
+		lat += float64(idx/4) * latRes
+		lng += float64(idx%4) * lngRes
+	}
+
+	return lat + latRes/2, lng + lngRes/2, true
+}
+
+// encodePlusCodePairs encodes the first digits of the pairs part of the plus
+// code of a location.
+func encodePlusCodePairs(lat, lng float64, digits int) string {
+	lat = math.Min(math.Max(lat, -90), 90) + 90
+	lng = math.Mod(lng+180, 360)
+	if lng < 0 {
+		lng += 360
+	}
+
+	var code strings.Builder
+	for i := 0; i < digits; i += 2 {
+		res := plusCodeResolutions[i/2]
+
+		d := math.Min(math.Floor(lat/res), 19)
+		lat -= d * res
+		code.WriteByte(plusCodeAlphabet[int(d)])
+
+		d = math.Min(math.Floor(lng/res), 19)
+		lng -= d * res
+		code.WriteByte(plusCodeAlphabet[int(d)])
+	}
+
+	return code.String()[:digits]
+}
+
+// recoverPlusCode decodes a short plus code like "WQ2G+XH" using the
+// nearest matching area to a reference location.
+func recoverPlusCode(code string, refLat, refLng float64) (lat, lng float64, ok bool) {
+	if isFullPlusCode(code) {
+		return decodePlusCode(code)
+	}
+	if !isPlusCode(code) {
+		return 0, 0, false
+	}
+
+	padding := plusCodeSeparator - strings.IndexByte(code, '+')
+	resolution := math.Pow(20, 2-float64(padding)/2)
+	half := resolution / 2
+
+	lat, lng, ok = decodePlusCode(encodePlusCodePairs(refLat, refLng, padding) + code)
+	if !ok {
+		return 0, 0, false
+	}
+
+	if refLat+half < lat && lat-resolution >= -90 {
+		lat -= resolution
+	} else if refLat-half > lat && lat+resolution <= 90 {
+		lat += resolution
+	}
+	if refLng+half < lng {
+		lng -= resolution
+	} else if refLng-half > lng {
+		lng += resolution
+	}
+	if lng > 180 {
+		lng -= 360
+	} else if lng < -180 {
+		lng += 360
+	}
+
+	return lat, lng, true
+}
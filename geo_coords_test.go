@@ -0,0 +1,84 @@
+package query
+
+import (
+	"math"
+	"testing"
+)
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 0.001
+}
+
+func TestParseCoordinates(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		In       string
+		Lat, Lng float64
+		OK       bool
+	}{
+		{"59.91,10.75", 59.91, 10.75, true},
+		{"59.91, -10.75", 59.91, -10.75, true},
+		{"-33.87 151.21", -33.87, 151.21, true},
+		{"91,10", 0, 0, false},
+		{"59.91", 0, 0, false},
+		{"Paris, TX", 0, 0, false},
+		{"nan,nan", 0, 0, false},
+		{"10, NaN", 0, 0, false},
+		{"inf,10", 0, 0, false},
+	}
+
+	for _, test := range tests {
+		lat, lng, ok := parseCoordinates(test.In)
+		if ok != test.OK || lat != test.Lat || lng != test.Lng {
+			t.Errorf("%q: want %v %v %v, got %v %v %v", test.In, test.Lat, test.Lng, test.OK, lat, lng, ok)
+		}
+	}
+}
+
+func TestDecodeGeohash(t *testing.T) {
+	t.Parallel()
+
+	lat, lng, ok := decodeGeohash("ezs42")
+	if !ok || math.Abs(lat-42.605) > 0.03 || math.Abs(lng+5.603) > 0.03 {
+		t.Errorf("ezs42 decoded to %v %v %v", lat, lng, ok)
+	}
+
+	for _, word := range []string{"bergen", "oslo", "u4x", "90210", "10115", "gh:", "gh:ezs42a"} {
+		if _, _, ok := decodeGeohash(word); ok {
+			t.Errorf("%q should not be a geohash", word)
+		}
+	}
+
+	// Prefixed hashes may be all digits.
+	if lat, lng, ok = decodeGeohash("GH:90210"); !ok || lat < 0 || lat > 45 || lng < -135 || lng > -90 {
+		t.Errorf("gh:90210 decoded to %v %v %v", lat, lng, ok)
+	}
+	if prefixed, _, _ := decodeGeohash("gh:ezs42"); prefixed != 42.60498046875 {
+		t.Errorf("gh:ezs42 decoded to %v", prefixed)
+	}
+}
+
+func TestPlusCodes(t *testing.T) {
+	t.Parallel()
+
+	lat, lng, ok := decodePlusCode("8FVC9G8F+6X")
+	if !ok || !near(lat, 47.365562) || !near(lng, 8.524938) {
+		t.Errorf("full code decoded to %v %v %v", lat, lng, ok)
+	}
+
+	short, shortLng, ok := recoverPlusCode("9G8F+6X", 47.4, 8.6)
+	if !ok || !near(short, lat) || !near(shortLng, lng) {
+		t.Errorf("short code recovered to %v %v %v", short, shortLng, ok)
+	}
+
+	if _, _, ok = decodePlusCode("9G8F+6X"); ok {
+		t.Error("short codes need a reference location")
+	}
+	for _, code := range []string{"+", "C++", "22+", "9FFG0000+", "8FVC9G8F+6"} {
+		want := code == "9FFG0000+"
+		if isPlusCode(code) != want {
+			t.Errorf("%q: want plus code %v", code, want)
+		}
+	}
+}
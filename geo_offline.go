@@ -0,0 +1,350 @@
+package query
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// OfflinePrimary looks places up in the dump before asking geonames.
+	OfflinePrimary = "primary"
+	// OfflineFallback looks places up in the dump when geonames fails.
+	OfflineFallback = "fallback"
+)
+
+// offlineNearbyRadius is how far in kilometres a location may be from a
+// place in the dump to be named after it.
+const offlineNearbyRadius = 50
+
+// earthRadius is the mean radius of the earth in kilometres.
+const earthRadius = 6371.0
+
+// Columns of the geonames dump files, see
+// https://download.geonames.org/export/dump/readme.txt
+const (
+	dumpID = iota
+	dumpName
+	dumpASCIIName
+	dumpAlternateNames
+	dumpLat
+	dumpLng
+	dumpFeatureClass
+	dumpFeatureCode
+	dumpCountryCode
+	dumpCC2
+	dumpAdmin1
+	dumpAdmin2
+	dumpAdmin3
+	dumpAdmin4
+	dumpPopulation
+	dumpElevation
+	dumpDEM
+	dumpTimeZone
+	dumpModified
+	dumpColumns
+)
+
+// gridCell is a one degree square of the earth.
+type gridCell struct {
+	Lat, Lng int
+}
+
+func cellOf(lat, lng float64) gridCell {
+	return gridCell{int(math.Floor(lat)), int(math.Floor(lng))}
+}
+
+// OfflineGeocoder looks up populated places in geonames dump files kept in
+// memory, by name or by nearest location.
+type OfflineGeocoder struct {
+	places []Place
+	ids    map[int]int
+	names  map[string][]int
+	grid   map[gridCell][]int
+}
+
+// LoadOfflineGeocoder reads a geonames dump like cities15000.txt or
+// allCountries.txt, and when given, alternateNames.txt and countryInfo.txt.
+func LoadOfflineGeocoder(dump, alternateNames, countryInfo string) (*OfflineGeocoder, error) {
+	f, err := os.Open(dump)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	g, err := NewOfflineGeocoder(f)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(alternateNames) != 0 {
+		if f, err = os.Open(alternateNames); err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if err = g.LoadAlternateNames(f); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(countryInfo) != 0 {
+		if f, err = os.Open(countryInfo); err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if err = g.LoadCountryInfo(f); err != nil {
+			return nil, err
+		}
+	}
+
+	return g, nil
+}
+
+// NewOfflineGeocoder indexes the populated places of a geonames dump, other
+// features like rivers and mountains are left out.
+func NewOfflineGeocoder(dump io.Reader) (*OfflineGeocoder, error) {
+	g := &OfflineGeocoder{
+		ids:   make(map[int]int),
+		names: make(map[string][]int),
+		grid:  make(map[gridCell][]int),
+	}
+
+	err := eachRow(dump, func(fields []string) error {
+		if len(fields) < dumpColumns || fields[dumpFeatureClass] != "P" {
+			return nil
+		}
+
+		place := Place{
+			Name:         fields[dumpName],
+			CountryCode:  fields[dumpCountryCode],
+			Country:      fields[dumpCountryCode],
+			AdminCode1:   fields[dumpAdmin1],
+			FeatureClass: fields[dumpFeatureClass],
+			FeatureCode:  fields[dumpFeatureCode],
+			TimeZone:     fields[dumpTimeZone],
+		}
+
+		var err error
+		if place.GeonameID, err = strconv.Atoi(fields[dumpID]); err != nil {
+			return err
+		}
+		if place.Lat, err = strconv.ParseFloat(fields[dumpLat], 64); err != nil {
+			return err
+		}
+		if place.Lng, err = strconv.ParseFloat(fields[dumpLng], 64); err != nil {
+			return err
+		}
+		if len(fields[dumpPopulation]) != 0 {
+			if place.Population, err = strconv.Atoi(fields[dumpPopulation]); err != nil {
+				return err
+			}
+		}
+
+		i := len(g.places)
+		g.places = append(g.places, place)
+		g.ids[place.GeonameID] = i
+
+		cell := cellOf(place.Lat, place.Lng)
+		g.grid[cell] = append(g.grid[cell], i)
+
+		g.index(fields[dumpName], i)
+		g.index(fields[dumpASCIIName], i)
+		if len(fields[dumpAlternateNames]) != 0 {
+			for _, name := range strings.Split(fields[dumpAlternateNames], ",") {
+				g.index(name, i)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	g.sortNames()
+	return g, nil
+}
+
+// LoadAlternateNames adds the names in a geonames alternateNames.txt to the
+// places already loaded.
+func (g *OfflineGeocoder) LoadAlternateNames(r io.Reader) error {
+	err := eachRow(r, func(fields []string) error {
+		if len(fields) < 4 {
+			return nil
+		}
+
+		// Not names but links, postal codes and other identifiers.
+		switch fields[2] {
+		case "link", "post", "wkdt", "unlc", "fr_1793":
+			return nil
+		}
+
+		id, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return err
+		}
+		if i, ok := g.ids[id]; ok {
+			g.index(fields[3], i)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	g.sortNames()
+	return nil
+}
+
+// LoadCountryInfo names the countries of the places already loaded from a
+// geonames countryInfo.txt, otherwise only their codes are known.
+func (g *OfflineGeocoder) LoadCountryInfo(r io.Reader) error {
+	countries := make(map[string]string)
+	err := eachRow(r, func(fields []string) error {
+		if len(fields) > 4 {
+			countries[fields[0]] = fields[4]
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for i, place := range g.places {
+		if name, ok := countries[place.CountryCode]; ok {
+			g.places[i].Country = name
+		}
+	}
+	return nil
+}
+
+// eachRow calls fn with the tab separated fields of every line, skipping
+// comments.
+func eachRow(r io.Reader, fn func(fields []string) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		if err := fn(strings.Split(line, "\t")); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+func (g *OfflineGeocoder) index(name string, i int) {
+	key := strings.ToLower(strings.TrimSpace(name))
+	if len(key) == 0 {
+		return
+	}
+
+	for _, j := range g.names[key] {
+		if j == i {
+			return
+		}
+	}
+	g.names[key] = append(g.names[key], i)
+}
+
+// sortNames puts the most populous places first for every name.
+func (g *OfflineGeocoder) sortNames() {
+	for _, indexes := range g.names {
+		sort.SliceStable(indexes, func(a, b int) bool {
+			return g.places[indexes[a]].Population > g.places[indexes[b]].Population
+		})
+	}
+}
+
+// Len is how many places are loaded.
+func (g *OfflineGeocoder) Len() int {
+	return len(g.places)
+}
+
+// Search finds the places named query in any of their names, most populous
+// first. No more than max places are returned when max is positive.
+func (g *OfflineGeocoder) Search(query string, max int) []Place {
+	indexes := g.names[strings.ToLower(strings.TrimSpace(query))]
+	if max > 0 && len(indexes) > max {
+		indexes = indexes[:max]
+	}
+
+	places := make([]Place, len(indexes))
+	for i, j := range indexes {
+		places[i] = g.places[j]
+	}
+	return places
+}
+
+// Nearest finds the place closest to a location, within
+// offlineNearbyRadius kilometres.
+func (g *OfflineGeocoder) Nearest(lat, lng float64) (Place, bool) {
+	radius := offlineNearbyRadius / (earthRadius * math.Pi / 180)
+	latCells := int(math.Ceil(radius))
+	lngCells := 180
+	if cos := math.Cos(lat * math.Pi / 180); cos > radius/180 {
+		lngCells = int(math.Min(math.Ceil(radius/cos), 180))
+	}
+
+	center := cellOf(lat, lng)
+	best, bestDist := -1, math.Inf(1)
+	for dLat := -latCells; dLat <= latCells; dLat++ {
+		for dLng := -lngCells; dLng <= lngCells; dLng++ {
+			cellLng := center.Lng + dLng
+			cellLng = (cellLng+180)%360 - 180
+			if cellLng < -180 {
+				cellLng += 360
+			}
+
+			for _, i := range g.grid[gridCell{center.Lat + dLat, cellLng}] {
+				p := g.places[i]
+				if d := distance(lat, lng, p.Lat, p.Lng); d < bestDist {
+					best, bestDist = i, d
+				}
+			}
+		}
+	}
+
+	if best < 0 || bestDist > offlineNearbyRadius {
+		return Place{}, false
+	}
+	return g.places[best], true
+}
+
+// locate finds the place a query means the same way getLocation does.
+func (g *OfflineGeocoder) locate(query string) (Place, error) {
+	name, qualifier := splitPlaceQualifier(query)
+
+	candidates := g.Search(name, geoCandidates)
+	if len(qualifier) != 0 {
+		if place, ok := qualifiedPlace(qualifier, candidates); ok {
+			return place, nil
+		}
+		candidates = g.Search(query, geoCandidates)
+	}
+	if len(candidates) == 0 {
+		return Place{}, geoErr{query}
+	}
+
+	return pickPlace(name, candidates)
+}
+
+// distance is the great circle distance between two locations in
+// kilometres.
+func distance(lat1, lng1, lat2, lng2 float64) float64 {
+	const rad = math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLng := (lng2 - lng1) * rad
+
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(a))
+}
@@ -0,0 +1,63 @@
+package query
+
+import (
+	"strings"
+	"testing"
+)
+
+const testDump = "3143244\tOslo\tOslo\tChristiania,Kristiania\t59.91273\t10.74609\tP\tPPLC\tNO\t\t12\t0301\t\t\t1082575\t\t26\tEurope/Oslo\t2024-01-01\n" +
+	"3139075\tSandvika\tSandvika\t\t59.88917\t10.52306\tP\tPPLA2\tNO\t\t30\t3024\t\t\t20230\t\t18\tEurope/Oslo\t2024-01-01\n" +
+	"2988507\tParis\tParis\tLutece\t48.85341\t2.3488\tP\tPPLC\tFR\t\t11\t75\t\t\t2138551\t\t42\tEurope/Paris\t2024-01-01\n" +
+	"4717560\tParis\tParis\t\t33.66094\t-95.55551\tP\tPPLA2\tUS\t\tTX\t277\t\t\t24782\t\t183\tAmerica/Chicago\t2024-01-01\n" +
+	"3137400\tSognefjorden\tSognefjorden\t\t61.1\t5.5\tH\tFJD\tNO\t\t46\t\t\t\t0\t\t0\tEurope/Oslo\t2024-01-01\n"
+
+func TestOfflineGeocoder(t *testing.T) {
+	t.Parallel()
+
+	g, err := NewOfflineGeocoder(strings.NewReader(testDump))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Len() != 4 {
+		t.Errorf("only populated places should be loaded, got %d", g.Len())
+	}
+
+	err = g.LoadAlternateNames(strings.NewReader("1\t3143244\tfi\tOslo\t\t\t\t\t\t\n" +
+		"2\t2988507\tzh\t巴黎\t\t\t\t\t\t\n" +
+		"3\t2988507\tlink\thttps://en.wikipedia.org/wiki/Paris\t\t\t\t\t\t\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = g.LoadCountryInfo(strings.NewReader("#ISO\tISO3\tISO-Numeric\tfips\tCountry\n" +
+		"NO\tNOR\t578\tNO\tNorway\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if places := g.Search("巴黎", 0); len(places) != 1 || places[0].CountryCode != "FR" {
+		t.Errorf("alternate names should be searchable: %#v", places)
+	}
+	if places := g.Search("kristiania", 0); len(places) != 1 || places[0].Country != "Norway" {
+		t.Errorf("dump alternate names should be searchable: %#v", places)
+	}
+
+	place, err := g.locate("Paris")
+	if err != nil || place.CountryCode != "FR" {
+		t.Errorf("most populous place should win: %#v %v", place, err)
+	}
+	place, err = g.locate("paris, tx")
+	if err != nil || place.AdminCode1 != "TX" || place.TimeZone != "America/Chicago" {
+		t.Errorf("qualified place should be found: %#v %v", place, err)
+	}
+	if _, err = g.locate("sognefjorden"); err == nil {
+		t.Error("features that are not places should not be found")
+	}
+
+	place, ok := g.Nearest(59.9, 10.6)
+	if !ok || place.Name != "Sandvika" {
+		t.Errorf("want nearest Sandvika, got %#v", place)
+	}
+	if _, ok = g.Nearest(0, 0); ok {
+		t.Error("nothing should be near null island")
+	}
+}
@@ -0,0 +1,157 @@
+package query
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolvePlaceOverrides(t *testing.T) {
+	t.Parallel()
+
+	file := filepath.Join(t.TempDir(), "places.toml")
+	err := os.WriteFile(file, []byte(`
+[hq]
+alias = "Portland"
+
+[PORTLAND]
+name = "File Portland"
+lat = 43.66
+lng = -70.26
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := &Config{Places: map[string]PlaceOverride{
+		"Portland": {Name: "Portland", Admin1: "Oregon", Country: "United States", Lat: 45.52, Lng: -122.68},
+		"Loop":     {Alias: "pool"},
+		"pool":     {Alias: "oslo"},
+		"ping":     {Alias: "Pong"},
+		"pong":     {Alias: "ping"},
+	}}
+	if err = conf.LoadPlaces(file); err != nil {
+		t.Fatal(err)
+	}
+
+	place, err := resolvePlace("HQ", conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if place.Admin1 != "Oregon" || place.Lat != 45.52 {
+		t.Errorf("alias should resolve to the config override: %#v", place)
+	}
+
+	place, err = resolvePlace("loop", conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if place.Name != "Oslo" {
+		t.Errorf("aliases should fall through to the built in places: %#v", place)
+	}
+
+	if _, err = resolvePlace("ping", conf); err == nil || !strings.Contains(err.Error(), "alias loop") {
+		t.Error("aliases pointing at each other should fail:", err)
+	}
+}
+
+func TestPlaceOverrideWithoutLocation(t *testing.T) {
+	t.Parallel()
+
+	file := filepath.Join(t.TempDir(), "places.toml")
+	if err := os.WriteFile(file, []byte("[hq]\nname = \"HQ\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	conf := &Config{}
+	if err := conf.LoadPlaces(file); err == nil {
+		t.Error("a place without alias or location should be rejected")
+	}
+
+	conf = &Config{Places: map[string]PlaceOverride{"hq": {Name: "HQ"}}}
+	if _, err := resolvePlace("hq", conf); err == nil {
+		t.Error("a place without alias or location should not resolve")
+	}
+
+	config := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(config, []byte("[places.hq]\nname = \"HQ\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if NewConfig(config) != nil {
+		t.Error("a config with a place without alias or location should be rejected")
+	}
+}
+
+func TestPickPlace(t *testing.T) {
+	t.Parallel()
+
+	parisFR := Place{Name: "Paris", CountryCode: "FR", Population: 2138551}
+	parisTX := Place{Name: "Paris", CountryCode: "US", AdminCode1: "TX", Population: 25171}
+	parisON := Place{Name: "Paris", CountryCode: "CA", AdminCode1: "08", Population: 12310}
+	portlandOR := Place{Name: "Portland", CountryCode: "US", AdminCode1: "OR", Population: 652503}
+	portlandME := Place{Name: "Portland", CountryCode: "US", AdminCode1: "ME", Population: 68408}
+
+	place, err := pickPlace("paris", []Place{parisFR, parisTX, parisON})
+	if err != nil {
+		t.Error(err)
+	} else if place != parisFR {
+		t.Errorf("small places with the same name should not be ambiguous: %#v", place)
+	}
+
+	_, err = pickPlace("Portland", []Place{portlandOR, portlandME, parisTX})
+	if err == nil {
+		t.Fatal("expected an ambiguous place")
+	}
+	if want := "Did you mean Portland, OR or Portland, ME?"; err.Error() != want {
+		t.Errorf("want %q, got %q", want, err.Error())
+	}
+
+	place, err = pickPlace("Portlnd", []Place{portlandOR, portlandME})
+	if err != nil || place != portlandOR {
+		t.Errorf("fuzzy matches should pick the best match: %#v %v", place, err)
+	}
+}
+
+func TestSplitPlaceQualifier(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		In, Name, Qualifier string
+	}{
+		{"Paris", "Paris", ""},
+		{"Paris, TX", "Paris", "TX"},
+		{" Portland , Oregon ", "Portland", "Oregon"},
+		{"Washington, D.C., US", "Washington, D.C.", "US"},
+	}
+
+	for _, test := range tests {
+		name, qualifier := splitPlaceQualifier(test.In)
+		if name != test.Name || qualifier != test.Qualifier {
+			t.Errorf("%q: want %q %q, got %q %q", test.In, test.Name, test.Qualifier, name, qualifier)
+		}
+	}
+
+	if !(Place{CountryCode: "US", Admin1: "Texas"}).matches("texas") {
+		t.Error("qualifiers should match state names")
+	}
+}
+
+func TestPlaceDisplayName(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Place Place
+		Want  string
+	}{
+		{Place{Name: "Oslo", Country: "Norway"}, "Oslo, Norway"},
+		{Place{Name: "59.9100, 10.7500"}, "59.9100, 10.7500"},
+		{Place{Name: "HQ", Lat: 59.91, Lng: 10.75}, "HQ"},
+	}
+
+	for _, test := range tests {
+		if got := test.Place.displayName(); got != test.Want {
+			t.Errorf("want %q, got %q", test.Want, got)
+		}
+	}
+}
@@ -36,6 +36,7 @@ func GithubStars(userOrRepo string, conf *Config) (count int, err error) {
 
 		repo, _, err := client.Repositories.Get(ctx, user, repoName)
 		if err != nil {
+			conf.log().Warn("github query failed", "repo", userOrRepo, "err", err)
 			return 0, err
 		}
 
@@ -49,6 +50,7 @@ func GithubStars(userOrRepo string, conf *Config) (count int, err error) {
 	for {
 		pagedRepos, resp, err := client.Repositories.List(ctx, userOrRepo, opts)
 		if err != nil {
+			conf.log().Warn("github query failed", "user", userOrRepo, "err", err)
 			return 0, err
 		}
 
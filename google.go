@@ -8,13 +8,14 @@ import (
 	"net/http"
 	"net/url"
 	"regexp"
+	"strconv"
+	"strings"
+	"time"
 )
 
-const (
+var (
 	googleURI = "https://www.googleapis.com/customsearch/v1?%s"
-)
 
-var (
 	rgxTags = regexp.MustCompile(`<[^>]*>`)
 )
 
@@ -39,7 +40,23 @@ type GoogleSearchItem struct {
 
 	CacheID string `json:"cacheId"`
 	Kind    string `json:"kind"`
-	// pagemap ignored
+	Mime    string `json:"mime"`
+
+	Pagemap GooglePagemap `json:"pagemap"`
+
+	// Image is only set for image searches.
+	Image *GoogleSearchImage `json:"image"`
+}
+
+// GoogleSearchImage describes the image found by an image search
+type GoogleSearchImage struct {
+	ContextLink     string `json:"contextLink"`
+	Width           int    `json:"width"`
+	Height          int    `json:"height"`
+	ByteSize        int    `json:"byteSize"`
+	ThumbnailLink   string `json:"thumbnailLink"`
+	ThumbnailWidth  int    `json:"thumbnailWidth"`
+	ThumbnailHeight int    `json:"thumbnailHeight"`
 }
 
 // GoogleSearchInformation is meta about the search
@@ -69,38 +86,205 @@ type GoogleQuery struct {
 	Safe           string `json:"safe"`
 }
 
-// Google performs a query and returns a formatted result.
-func Google(query string, conf *Config) (output string, err error) {
-	if len(conf.GoogleSearchCXID) == 0 || len(conf.GoogleSearchAPIKey) == 0 {
-		return output, errors.New("cannot use google search without google_search_api_key and google_search_cx_id")
+// GoogleOptions narrows down a google custom search. The zero value
+// performs an unrestricted search.
+type GoogleOptions struct {
+	// Safe is the safe search level, either "active" or "off".
+	Safe string
+	// SiteSearch restricts results to the given site.
+	SiteSearch string
+	// SiteExclude excludes SiteSearch from the results instead.
+	SiteExclude bool
+	// DateRestrict restricts results by date, for example "d3" for the last
+	// three days or "w1" for the last week.
+	DateRestrict string
+	// Language restricts results to a language, for example "lang_en".
+	Language string
+	// Country boosts results from a country, for example "no".
+	Country string
+	// ExactTerms must appear in every result.
+	ExactTerms string
+	// ExcludeTerms must not appear in any result.
+	ExcludeTerms string
+	// FileType restricts results to files of an extension, for example "pdf".
+	FileType string
+	// Num is the number of results to return, 1 if not set.
+	Num int
+}
+
+func (g GoogleOptions) encode(params url.Values) {
+	setNonEmpty(params, "safe", g.Safe)
+	setNonEmpty(params, "siteSearch", g.SiteSearch)
+	if len(g.SiteSearch) != 0 && g.SiteExclude {
+		params.Set("siteSearchFilter", "e")
+	}
+	setNonEmpty(params, "dateRestrict", g.DateRestrict)
+	setNonEmpty(params, "lr", g.Language)
+	setNonEmpty(params, "gl", g.Country)
+	setNonEmpty(params, "exactTerms", g.ExactTerms)
+	setNonEmpty(params, "excludeTerms", g.ExcludeTerms)
+	setNonEmpty(params, "fileType", g.FileType)
+
+	num := 1
+	if g.Num > 0 {
+		num = g.Num
+	}
+	params.Set("num", strconv.Itoa(num))
+}
+
+// parseGoogleQuery pulls the site:, after: and filetype: operators out of
+// a query and turns them into options. after: accepts either a date
+// (2006-01-02) or a relative period such as 3d, 2w, 6m or 1y. A query of
+// only operators is kept whole since google refuses to search for nothing.
+func parseGoogleQuery(query string, now time.Time) (string, GoogleOptions) {
+	var opts GoogleOptions
+	var terms []string
+
+	for _, field := range strings.Fields(query) {
+		key, value, ok := strings.Cut(field, ":")
+		if !ok || len(value) == 0 {
+			terms = append(terms, field)
+			continue
+		}
+
+		switch strings.ToLower(key) {
+		case "site":
+			opts.SiteSearch = value
+		case "-site":
+			opts.SiteSearch = value
+			opts.SiteExclude = true
+		case "filetype":
+			opts.FileType = value
+		case "after":
+			restrict, ok := googleDateRestrict(value, now)
+			if !ok {
+				terms = append(terms, field)
+				continue
+			}
+			opts.DateRestrict = restrict
+		default:
+			terms = append(terms, field)
+		}
 	}
 
+	if len(terms) == 0 {
+		return strings.TrimSpace(query), opts
+	}
+	return strings.Join(terms, " "), opts
+}
+
+func googleDateRestrict(after string, now time.Time) (string, bool) {
+	if date, err := time.Parse("2006-01-02", after); err == nil {
+		days := int(now.Sub(date).Hours() / 24)
+		if days < 1 {
+			days = 1
+		}
+		return "d" + strconv.Itoa(days), true
+	}
+
+	if len(after) < 2 {
+		return "", false
+	}
+
+	n, err := strconv.Atoi(after[:len(after)-1])
+	if err != nil || n < 1 {
+		return "", false
+	}
+
+	switch unit := strings.ToLower(after[len(after)-1:]); unit {
+	case "d", "w", "m", "y":
+		return unit + strconv.Itoa(n), true
+	default:
+		return "", false
+	}
+}
+
+// GoogleImageOptions narrows down a google image search.
+type GoogleImageOptions struct {
+	GoogleOptions
+
+	// Size is one of icon, small, medium, large, xlarge, xxlarge or huge.
+	Size string
+	// Type is one of clipart, face, lineart, stock, photo or animated.
+	Type string
+	// ColorType is one of color, gray, mono or trans.
+	ColorType string
+	// DominantColor is a color name such as "red" or "blue".
+	DominantColor string
+}
+
+func (g GoogleImageOptions) encode(params url.Values) {
+	g.GoogleOptions.encode(params)
+
+	params.Set("searchType", "image")
+	setNonEmpty(params, "imgSize", g.Size)
+	setNonEmpty(params, "imgType", g.Type)
+	setNonEmpty(params, "imgColorType", g.ColorType)
+	setNonEmpty(params, "imgDominantColor", g.DominantColor)
+}
+
+// GoogleResults performs a query and returns the decoded response.
+func GoogleResults(query string, opts GoogleOptions, conf *Config) (*GoogleSearch, error) {
 	params := make(url.Values)
+	opts.encode(params)
+	return googleSearch(query, params, conf)
+}
+
+// GoogleImageResults performs an image search and returns the decoded
+// response. Each item has its Image set.
+func GoogleImageResults(query string, opts GoogleImageOptions, conf *Config) (*GoogleSearch, error) {
+	params := make(url.Values)
+	opts.encode(params)
+	return googleSearch(query, params, conf)
+}
+
+func googleSearch(query string, params url.Values, conf *Config) (*GoogleSearch, error) {
+	if len(conf.GoogleSearchCXID) == 0 || len(conf.GoogleSearchAPIKey) == 0 {
+		return nil, errors.New("cannot use google search without google_search_api_key and google_search_cx_id")
+	}
+
 	params.Set("cx", conf.GoogleSearchCXID)
 	params.Set("key", conf.GoogleSearchAPIKey)
 	params.Set("q", query)
-	params.Set("num", "1")
 	u := fmt.Sprintf(googleURI, params.Encode())
 
-	var resp *http.Response
-	resp, err = http.Get(u)
+	resp, err := http.Get(u)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
+	defer resp.Body.Close()
+
+	conf.log().Debug("google query", "query", query, "searchType", params.Get("searchType"), "status", resp.StatusCode)
+
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Sprintf("\x02Google: Query returned %d", resp.StatusCode), nil
+		conf.log().Warn("google query failed", "status", resp.StatusCode)
+		return nil, StatusError{Service: "google", StatusCode: resp.StatusCode}
 	}
 
-	defer resp.Body.Close()
-
 	b, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	var results GoogleSearch
 	if err = json.Unmarshal(b, &results); err != nil {
+		return nil, err
+	}
+
+	return &results, nil
+}
+
+// Google performs a query and returns a formatted result. The query may
+// contain site:, after: and filetype: operators to narrow the search.
+func Google(query string, conf *Config) (output string, err error) {
+	query, opts := parseGoogleQuery(query, time.Now())
+
+	results, err := GoogleResults(query, opts, conf)
+	if err != nil {
+		if e, ok := err.(StatusError); ok {
+			return fmt.Sprintf("\x02Google: Query returned %d", e.StatusCode), nil
+		}
 		return "", err
 	}
 
@@ -114,6 +298,40 @@ func Google(query string, conf *Config) (output string, err error) {
 		results.Items[0].Link,
 		results.Items[0].Snippet,
 	)
+	if summary := results.Items[0].Pagemap.summary(); len(summary) != 0 {
+		output += " (" + summary + ")"
+	}
+
+	return output, nil
+}
+
+// GoogleImage performs an image search and returns a formatted result. The
+// query accepts the same operators as Google. Safe search is always on.
+func GoogleImage(query string, conf *Config) (output string, err error) {
+	query, opts := parseGoogleQuery(query, time.Now())
+	opts.Safe = "active"
+
+	results, err := GoogleImageResults(query, GoogleImageOptions{GoogleOptions: opts}, conf)
+	if err != nil {
+		if e, ok := err.(StatusError); ok {
+			return fmt.Sprintf("\x02Google Images: Query returned %d", e.StatusCode), nil
+		}
+		return "", err
+	}
+
+	if len(results.Items) == 0 || results.Items[0].Image == nil {
+		return "\x02Google Images: No results found.\x02", nil
+	}
+
+	item := results.Items[0]
+	output = fmt.Sprintf(
+		"\x02Google Images (\x02%dx%d\x02):\x02 %s - %s (%s)",
+		item.Image.Width,
+		item.Image.Height,
+		item.Link,
+		item.Title,
+		item.Image.ContextLink,
+	)
 
 	return output, nil
 }
@@ -0,0 +1,216 @@
+package query
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GooglePagemap is the structured data google extracted from a result page.
+// Google is loose with the types inside a pagemap so decoding never fails:
+// every scalar is kept as a string and anything else is dropped.
+type GooglePagemap struct {
+	// Metatags are the meta tags of the page such as og:title.
+	Metatags []map[string]string
+	// Images are the images google picked for the page.
+	Images []string
+	// Thumbnails are the thumbnails google generated for the page.
+	Thumbnails []GooglePagemapImage
+	// Rating is the aggregate rating of the page's subject if it has one.
+	Rating *GoogleRating
+	// Published is when the page's article was published if known.
+	Published time.Time
+
+	// Raw is every object in the pagemap keyed by its type, for example
+	// "product" or "newsarticle".
+	Raw map[string][]map[string]string
+}
+
+// GooglePagemapImage is an image inside a pagemap
+type GooglePagemapImage struct {
+	Src    string
+	Width  int
+	Height int
+}
+
+// GoogleRating is a rating inside a pagemap
+type GoogleRating struct {
+	Value float64
+	Best  float64
+	Count int
+}
+
+var googlePublishedKeys = []struct {
+	kind string
+	key  string
+}{
+	{"metatags", "article:published_time"},
+	{"metatags", "og:article:published_time"},
+	{"metatags", "datepublished"},
+	{"newsarticle", "datepublished"},
+	{"article", "datepublished"},
+	{"blogposting", "datepublished"},
+	{"metatags", "date"},
+}
+
+var googleTimeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05-0700",
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+}
+
+// UnmarshalJSON decodes the pagemap and fills in the typed fields. It only
+// returns an error when the input is not valid json.
+func (g *GooglePagemap) UnmarshalJSON(b []byte) error {
+	var kinds map[string]json.RawMessage
+	if err := json.Unmarshal(b, &kinds); err != nil {
+		if json.Valid(b) {
+			return nil
+		}
+		return err
+	}
+
+	g.Raw = make(map[string][]map[string]string, len(kinds))
+	for kind, raw := range kinds {
+		raw = bytes.TrimSpace(raw)
+		if len(raw) != 0 && raw[0] == '{' {
+			raw = append(append([]byte{'['}, raw...), ']')
+		}
+
+		var objects []json.RawMessage
+		if err := json.Unmarshal(raw, &objects); err != nil {
+			continue
+		}
+
+		for _, object := range objects {
+			var fields map[string]json.RawMessage
+			if err := json.Unmarshal(object, &fields); err != nil {
+				continue
+			}
+
+			values := make(map[string]string, len(fields))
+			for key, field := range fields {
+				if value, ok := googlePagemapScalar(field); ok {
+					values[strings.ToLower(key)] = value
+				}
+			}
+			g.Raw[strings.ToLower(kind)] = append(g.Raw[strings.ToLower(kind)], values)
+		}
+	}
+
+	g.Metatags = g.Raw["metatags"]
+
+	for _, image := range g.Raw["cse_image"] {
+		if src := image["src"]; len(src) != 0 {
+			g.Images = append(g.Images, src)
+		}
+	}
+	if og := g.Get("metatags", "og:image"); len(og) != 0 && len(g.Images) == 0 {
+		g.Images = append(g.Images, og)
+	}
+
+	for _, thumb := range g.Raw["cse_thumbnail"] {
+		width, _ := strconv.Atoi(thumb["width"])
+		height, _ := strconv.Atoi(thumb["height"])
+		g.Thumbnails = append(g.Thumbnails, GooglePagemapImage{
+			Src:    thumb["src"],
+			Width:  width,
+			Height: height,
+		})
+	}
+
+	for _, rating := range g.Raw["aggregaterating"] {
+		value, err := strconv.ParseFloat(rating["ratingvalue"], 64)
+		if err != nil {
+			continue
+		}
+
+		r := GoogleRating{Value: value, Best: 5}
+		if best, err := strconv.ParseFloat(rating["bestrating"], 64); err == nil && best > 0 {
+			r.Best = best
+		}
+		if count, err := strconv.Atoi(rating["ratingcount"]); err == nil {
+			r.Count = count
+		} else if count, err := strconv.Atoi(rating["reviewcount"]); err == nil {
+			r.Count = count
+		}
+
+		g.Rating = &r
+		break
+	}
+
+	for _, key := range googlePublishedKeys {
+		value := g.Get(key.kind, key.key)
+		if len(value) == 0 {
+			continue
+		}
+
+		for _, layout := range googleTimeLayouts {
+			if t, err := time.Parse(layout, value); err == nil {
+				g.Published = t
+				break
+			}
+		}
+		if !g.Published.IsZero() {
+			break
+		}
+	}
+
+	return nil
+}
+
+// Get returns the first value of key in any object of the kind, or
+// the empty string.
+func (g GooglePagemap) Get(kind, key string) string {
+	for _, object := range g.Raw[strings.ToLower(kind)] {
+		if value, ok := object[strings.ToLower(key)]; ok {
+			return value
+		}
+	}
+	return ""
+}
+
+func googlePagemapScalar(raw json.RawMessage) (string, bool) {
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return "", false
+	}
+
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case bool:
+		return strconv.FormatBool(v), true
+	default:
+		return "", false
+	}
+}
+
+// summary is a short description of the rich data for chat, or the empty
+// string if there is nothing interesting.
+func (g GooglePagemap) summary() string {
+	var parts []string
+	if g.Rating != nil {
+		rating := fmt.Sprintf("%s/%s",
+			strconv.FormatFloat(g.Rating.Value, 'f', -1, 64),
+			strconv.FormatFloat(g.Rating.Best, 'f', -1, 64),
+		)
+		if g.Rating.Count > 0 {
+			rating += fmt.Sprintf(" from %d ratings", g.Rating.Count)
+		}
+		parts = append(parts, rating)
+	}
+	if !g.Published.IsZero() {
+		parts = append(parts, "published "+g.Published.Format("2006-01-02"))
+	}
+
+	return strings.Join(parts, ", ")
+}
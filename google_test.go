@@ -0,0 +1,131 @@
+package query
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseGoogleQuery(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2020, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		In    string
+		Query string
+		Opts  GoogleOptions
+	}{
+		{"golang generics", "golang generics", GoogleOptions{}},
+		{"generics site:golang.org after:1w", "generics", GoogleOptions{SiteSearch: "golang.org", DateRestrict: "w1"}},
+		{"-site:reddit.com go", "go", GoogleOptions{SiteSearch: "reddit.com", SiteExclude: true}},
+		{"spec filetype:pdf after:2020-03-01", "spec", GoogleOptions{FileType: "pdf", DateRestrict: "d9"}},
+		{"after:tomorrow http://a.b", "after:tomorrow http://a.b", GoogleOptions{}},
+		{" site:golang.org ", "site:golang.org", GoogleOptions{SiteSearch: "golang.org"}},
+	}
+
+	for _, test := range tests {
+		query, opts := parseGoogleQuery(test.In, now)
+		if query != test.Query {
+			t.Errorf("%q: query was wrong: %q", test.In, query)
+		}
+		if opts != test.Opts {
+			t.Errorf("%q: opts were wrong: %#v", test.In, opts)
+		}
+	}
+}
+
+func TestGooglePagemap(t *testing.T) {
+	t.Parallel()
+
+	js := `{
+		"metatags": [{"og:image": "http://a/b.png", "article:published_time": "2019-04-01T10:00:00+02:00", "viewport": {"x": 1}}],
+		"aggregaterating": {"ratingvalue": 4.5, "ratingcount": "120"},
+		"cse_thumbnail": [{"src": "http://a/t.png", "width": "90", "height": 60}],
+		"broken": "nonsense"
+	}`
+
+	var item GoogleSearchItem
+	if err := json.Unmarshal([]byte(`{"title": "x", "pagemap": `+js+`}`), &item); err != nil {
+		t.Fatal(err)
+	}
+
+	p := item.Pagemap
+	if len(p.Images) != 1 || p.Images[0] != "http://a/b.png" {
+		t.Error("images were wrong:", p.Images)
+	}
+	if len(p.Thumbnails) != 1 || p.Thumbnails[0].Width != 90 || p.Thumbnails[0].Height != 60 {
+		t.Error("thumbnails were wrong:", p.Thumbnails)
+	}
+	if p.Rating == nil || p.Rating.Value != 4.5 || p.Rating.Best != 5 || p.Rating.Count != 120 {
+		t.Errorf("rating was wrong: %#v", p.Rating)
+	}
+	if p.Published.Year() != 2019 {
+		t.Error("published was wrong:", p.Published)
+	}
+	if _, ok := p.Metatags[0]["viewport"]; ok {
+		t.Error("non scalar metatag should be dropped")
+	}
+	if s := p.summary(); s != "4.5/5 from 120 ratings, published 2019-04-01" {
+		t.Error("summary was wrong:", s)
+	}
+
+	if err := json.Unmarshal([]byte(`{"pagemap": [1, 2]}`), &item); err != nil {
+		t.Error("a strange pagemap should not fail decoding:", err)
+	}
+}
+
+// TestGoogleImageResults swaps googleURI so it can't run in parallel.
+func TestGoogleImageResults(t *testing.T) {
+	var params map[string][]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params = r.URL.Query()
+		fmt.Fprint(w, `{"items": [{"title": "Gopher", "link": "http://a/g.png", "mime": "image/png",
+			"image": {"contextLink": "http://a", "width": 640, "height": 480}}]}`)
+	}))
+	defer srv.Close()
+
+	old := googleURI
+	googleURI = srv.URL + "?%s"
+	defer func() { googleURI = old }()
+
+	opts := GoogleImageOptions{
+		GoogleOptions: GoogleOptions{Safe: "active"},
+		Size:          "large",
+		Type:          "photo",
+		DominantColor: "blue",
+	}
+	conf := &Config{GoogleSearchAPIKey: "key", GoogleSearchCXID: "cx"}
+	results, err := GoogleImageResults("gopher", opts, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params == nil {
+		t.Fatal("no request was made")
+	}
+
+	want := map[string]string{
+		"q":                "gopher",
+		"searchType":       "image",
+		"safe":             "active",
+		"imgSize":          "large",
+		"imgType":          "photo",
+		"imgDominantColor": "blue",
+		"num":              "1",
+	}
+	for key, value := range want {
+		if v := params[key]; len(v) != 1 || v[0] != value {
+			t.Errorf("%s: want %q, got %q", key, value, v)
+		}
+	}
+	if _, ok := params["imgColorType"]; ok {
+		t.Error("empty options should not be sent")
+	}
+
+	if len(results.Items) != 1 || results.Items[0].Image == nil || results.Items[0].Image.Width != 640 {
+		t.Errorf("image was not decoded: %#v", results.Items)
+	}
+}
@@ -48,6 +48,8 @@ func GetShortURL(longURL string, conf *Config) (short string, err error) {
 	}
 	defer resp.Body.Close()
 
+	conf.log().Debug("url shortener query", "status", resp.StatusCode)
+
 	var jsonObj URLShortenResponse
 	decoder := json.NewDecoder(resp.Body)
 	err = decoder.Decode(&jsonObj)
@@ -0,0 +1,231 @@
+package query
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	geoTimezoneURI = "http://api.geonames.org/timezoneJSON?%s"
+	localTimeFmt   = "15:04 Mon 2 Jan"
+)
+
+// timeConversionRe matches conversions like "15:00 Oslo in New York" or
+// "3pm in Tokyo", the place converted from is optional.
+var timeConversionRe = regexp.MustCompile(
+	`(?i)^(\d{1,2}(?::\d{2})?\s*(?:am|pm)?)(?:\s+(.*?))?\s+(?:in|to)\s+(.+)$`)
+
+// timeConversion is a parsed conversion query.
+type timeConversion struct {
+	Hour, Minute int
+	From, To     string
+}
+
+// parseTimeConversion reads conversions like "15:00 Oslo in New York".
+func parseTimeConversion(query string) (conv timeConversion, ok bool) {
+	m := timeConversionRe.FindStringSubmatch(strings.TrimSpace(query))
+	if m == nil {
+		return conv, false
+	}
+
+	clock := strings.ToLower(strings.ReplaceAll(m[1], " ", ""))
+	meridiem := ""
+	if strings.HasSuffix(clock, "am") || strings.HasSuffix(clock, "pm") {
+		meridiem = clock[len(clock)-2:]
+		clock = clock[:len(clock)-2]
+	}
+
+	hour, minute, _ := strings.Cut(clock, ":")
+	var err error
+	if conv.Hour, err = strconv.Atoi(hour); err != nil {
+		return conv, false
+	}
+	if len(minute) != 0 {
+		if conv.Minute, err = strconv.Atoi(minute); err != nil {
+			return conv, false
+		}
+	}
+
+	switch {
+	case conv.Minute > 59:
+		return conv, false
+	case len(meridiem) == 0:
+		if conv.Hour > 23 {
+			return conv, false
+		}
+	case conv.Hour < 1 || conv.Hour > 12:
+		return conv, false
+	default:
+		conv.Hour %= 12
+		if meridiem == "pm" {
+			conv.Hour += 12
+		}
+	}
+
+	conv.From = strings.TrimSpace(m[2])
+	conv.To = strings.TrimSpace(m[3])
+	return conv, true
+}
+
+// Time tells the local time of a place or the user's saved location, or
+// converts a time between places with "15:00 Oslo in New York". Time zone
+// names like "UTC" or "Asia/Tokyo" may be used in place of places.
+func Time(query string, conf *Config) (output string, err error) {
+	now := time.Now()
+
+	if conv, ok := parseTimeConversion(query); ok {
+		return timeConvert(conv, now, conf)
+	}
+
+	name, loc, err := zoneFor(query, conf)
+	if err != nil {
+		if out, ok := weatherPlaceErrOutput("Time", err); ok {
+			return out, nil
+		}
+		return "", err
+	}
+
+	local := now.In(loc)
+	return fmt.Sprintf("\x02Time:\x02 %s \x02=>\x02 %s (%s)",
+		name, local.Format(localTimeFmt), formatZone(local)), nil
+}
+
+func timeConvert(conv timeConversion, now time.Time, conf *Config) (output string, err error) {
+	fromName, from, err := zoneFor(conv.From, conf)
+	if err == nil {
+		var toName string
+		var to *time.Location
+		if toName, to, err = zoneFor(conv.To, conf); err == nil {
+			now = now.In(from)
+			t := time.Date(now.Year(), now.Month(), now.Day(), conv.Hour, conv.Minute, 0, 0, from)
+
+			return fmt.Sprintf("\x02Time:\x02 %s in %s (%s) \x02=>\x02 %s in %s (%s)",
+				t.Format(localTimeFmt), fromName, formatZone(t),
+				t.In(to).Format(localTimeFmt), toName, formatZone(t.In(to))), nil
+		}
+	}
+
+	if out, ok := weatherPlaceErrOutput("Time", err); ok {
+		return out, nil
+	}
+	return "", err
+}
+
+// zoneFor finds the time zone of a place, the user's saved location when
+// the query is empty, or of a time zone name.
+func zoneFor(query string, conf *Config) (name string, loc *time.Location, err error) {
+	query = strings.TrimSpace(query)
+	if isZoneName(query) {
+		if loc, err = time.LoadLocation(query); err == nil {
+			return loc.String(), loc, nil
+		}
+	}
+
+	place, err := resolveUserPlace(query, conf)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if len(place.TimeZone) == 0 {
+		place.TimeZone = placeTimeZone(place, conf)
+	}
+
+	return place.displayName(), place.location(), nil
+}
+
+// isZoneName checks if a query looks like a time zone name rather than a
+// place. Abbreviations other than UTC and GMT are ambiguous and not used.
+func isZoneName(query string) bool {
+	switch strings.ToUpper(query) {
+	case "UTC", "GMT":
+		return true
+	}
+	return strings.Contains(query, "/") && !strings.Contains(query, " ")
+}
+
+// placeTimeZone finds the time zone of a place that doesn't know it, with
+// the offline geocoder or geonames. It's empty when neither knows, leaving
+// the place to guess from its longitude.
+func placeTimeZone(place Place, conf *Config) string {
+	if conf.Geocoder != nil {
+		if near, ok := conf.Geocoder.Nearest(place.Lat, place.Lng); ok && len(near.TimeZone) != 0 {
+			return near.TimeZone
+		}
+	}
+
+	zone, err := geonamesTimeZone(place.Lat, place.Lng, conf)
+	if err != nil {
+		conf.log().Debug("time zone lookup failed", "place", place.Name, "err", err)
+		return ""
+	}
+	return zone
+}
+
+// geonamesTimeZone asks geonames for the IANA time zone of a location.
+func geonamesTimeZone(lat, lng float64, conf *Config) (string, error) {
+	if len(conf.GeonamesID) == 0 {
+		return "", errors.New("geo cannot be used without geonames_id in config")
+	}
+
+	params := url.Values{}
+	params.Set("username", conf.GeonamesID)
+	params.Set("lat", strconv.FormatFloat(lat, 'f', -1, 64))
+	params.Set("lng", strconv.FormatFloat(lng, 'f', -1, 64))
+
+	resp, err := http.Get(fmt.Sprintf(geoTimezoneURI, params.Encode()))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	conf.log().Debug("geonames time zone query", "lat", lat, "lng", lng, "status", resp.StatusCode)
+
+	if resp.StatusCode != http.StatusOK {
+		return "", StatusError{Service: "geonames", StatusCode: resp.StatusCode}
+	}
+
+	var data struct {
+		TimezoneID string `json:"timezoneId"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return "", err
+	}
+	if len(data.TimezoneID) == 0 {
+		return "", fmt.Errorf("geonames has no time zone for %s", formatCoordinates(lat, lng))
+	}
+
+	return data.TimezoneID, nil
+}
+
+// formatZone describes the time zone of a time like "CEST, UTC+2, DST".
+func formatZone(t time.Time) string {
+	abbrev, offset := t.Zone()
+
+	zone := "UTC"
+	if offset != 0 {
+		sign := '+'
+		if offset < 0 {
+			sign, offset = '-', -offset
+		}
+		zone = fmt.Sprintf("UTC%c%d", sign, offset/3600)
+		if minutes := offset % 3600 / 60; minutes != 0 {
+			zone += fmt.Sprintf(":%02d", minutes)
+		}
+	}
+
+	// Zones without an abbreviation of their own are named by offset.
+	if len(abbrev) != 0 && abbrev != "UTC" && abbrev[0] != '+' && abbrev[0] != '-' {
+		zone = abbrev + ", " + zone
+	}
+	if t.IsDST() {
+		zone += ", DST"
+	}
+	return zone
+}
@@ -0,0 +1,70 @@
+package query
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTimeConversion(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		In   string
+		Conv timeConversion
+		OK   bool
+	}{
+		{"15:00 Oslo in New York", timeConversion{15, 0, "Oslo", "New York"}, true},
+		{"3pm in Tokyo", timeConversion{15, 0, "", "Tokyo"}, true},
+		{"12:30 am UTC to Asia/Tokyo", timeConversion{0, 30, "UTC", "Asia/Tokyo"}, true},
+		{"9 Paris, TX in Paris, FR", timeConversion{9, 0, "Paris, TX", "Paris, FR"}, true},
+		{"Tokyo", timeConversion{}, false},
+		{"25:00 Oslo in Tokyo", timeConversion{}, false},
+		{"13pm in Tokyo", timeConversion{}, false},
+	}
+
+	for _, test := range tests {
+		conv, ok := parseTimeConversion(test.In)
+		if ok != test.OK || (ok && conv != test.Conv) {
+			t.Errorf("%q: want %#v %v, got %#v %v", test.In, test.Conv, test.OK, conv, ok)
+		}
+	}
+}
+
+func TestFormatZone(t *testing.T) {
+	t.Parallel()
+
+	summer := time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		Zone string
+		Want string
+	}{
+		{"UTC", "UTC"},
+		{"Europe/Oslo", "CEST, UTC+2, DST"},
+		{"Asia/Kolkata", "IST, UTC+5:30"},
+		{"America/St_Johns", "NDT, UTC-2:30, DST"},
+	}
+
+	for _, test := range tests {
+		loc, err := time.LoadLocation(test.Zone)
+		if err != nil {
+			t.Skip("time zone database unavailable:", err)
+		}
+		if got := formatZone(summer.In(loc)); got != test.Want {
+			t.Errorf("%s: want %q, got %q", test.Zone, test.Want, got)
+		}
+	}
+}
+
+func TestTimeConvert(t *testing.T) {
+	t.Parallel()
+
+	out, err := Time("12:00 UTC in oslo", &Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "12:00") || !strings.Contains(out, "in Oslo, Norway") {
+		t.Errorf("want 12:00 UTC converted to oslo, got %q", out)
+	}
+}
@@ -0,0 +1,190 @@
+package query
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	// prefLocation is the preference key of a user's default location as
+	// they wrote it.
+	prefLocation = "location"
+	// prefPlace is the preference key of the place prefLocation resolved
+	// to, saved as json so it needn't be looked up again.
+	prefPlace = "place"
+)
+
+// PrefStore keeps preferences per user, such as a default location. Users
+// are identified by whatever the caller passes to ForUser, a nick or an
+// account name.
+type PrefStore interface {
+	// Get returns the value of a preference and whether it was set.
+	Get(user, key string) (string, bool, error)
+	// Set saves the value of a preference.
+	Set(user, key, value string) error
+}
+
+// FilePrefStore is a PrefStore kept in a json file. It is safe for
+// concurrent use.
+type FilePrefStore struct {
+	file string
+
+	mut   sync.RWMutex
+	prefs map[string]map[string]string
+}
+
+// NewFilePrefStore loads the preferences in file, which does not have to
+// exist yet.
+func NewFilePrefStore(file string) (*FilePrefStore, error) {
+	f := &FilePrefStore{
+		file:  file,
+		prefs: make(map[string]map[string]string),
+	}
+
+	b, err := ioutil.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return f, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal(b, &f.prefs); err != nil {
+		return nil, fmt.Errorf("failed to decode prefs file %s: %w", file, err)
+	}
+
+	return f, nil
+}
+
+// Get returns the value of a preference, users are compared ignoring case.
+func (f *FilePrefStore) Get(user, key string) (string, bool, error) {
+	f.mut.RLock()
+	defer f.mut.RUnlock()
+
+	value, ok := f.prefs[strings.ToLower(user)][key]
+	return value, ok, nil
+}
+
+// Set saves the value of a preference and writes the file.
+func (f *FilePrefStore) Set(user, key, value string) error {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	user = strings.ToLower(user)
+	if f.prefs[user] == nil {
+		f.prefs[user] = make(map[string]string)
+	}
+	f.prefs[user][key] = value
+
+	b, err := json.MarshalIndent(f.prefs, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write to the side and rename so a crash never leaves half a file.
+	tmp, err := ioutil.TempFile(filepath.Dir(f.file), filepath.Base(f.file)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), f.file)
+}
+
+// ForUser returns a copy of the config for queries made by user, which lets
+// location aware queries fall back to the user's saved location.
+func (c *Config) ForUser(user string) *Config {
+	conf := *c
+	conf.user = user
+	return &conf
+}
+
+// userPlace returns the place the user saved, ok is false when they have
+// not saved one. Locations saved before places were kept with them are
+// resolved and saved again the first time.
+func (c *Config) userPlace() (place Place, ok bool, err error) {
+	if len(c.user) == 0 || c.Prefs == nil {
+		return place, false, nil
+	}
+
+	saved, ok, err := c.Prefs.Get(c.user, prefPlace)
+	if err != nil {
+		return place, false, err
+	}
+	if ok {
+		if err = json.Unmarshal([]byte(saved), &place); err != nil {
+			return place, false, fmt.Errorf("failed to decode saved place of %s: %w", c.user, err)
+		}
+		return place, true, nil
+	}
+
+	location, _, err := c.Prefs.Get(c.user, prefLocation)
+	if err != nil || len(location) == 0 {
+		return place, false, err
+	}
+	if place, err = c.setUserLocation(location); err != nil {
+		return place, false, err
+	}
+	return place, true, nil
+}
+
+// setUserLocation resolves the location of the user and saves it along with
+// the place it was resolved to, including its time zone, so later queries
+// needn't look it up again.
+func (c *Config) setUserLocation(location string) (Place, error) {
+	if len(c.user) == 0 || c.Prefs == nil {
+		return Place{}, errors.New("saving locations needs a user and prefs_file in config")
+	}
+
+	place, err := resolvePlace(location, c)
+	if err != nil {
+		return place, err
+	}
+	if len(place.TimeZone) == 0 {
+		place.TimeZone = placeTimeZone(place, c)
+	}
+
+	b, err := json.Marshal(place)
+	if err != nil {
+		return place, err
+	}
+	if err = c.Prefs.Set(c.user, prefLocation, location); err != nil {
+		return place, err
+	}
+	return place, c.Prefs.Set(c.user, prefPlace, string(b))
+}
+
+// errNoLocation is returned when a query has no location and the user has
+// not saved one.
+var errNoLocation = errors.New("No location given, save one with: set <location>")
+
+// resolveUserPlace finds the place of a query, or the user's saved location
+// when the query is empty.
+func resolveUserPlace(query string, conf *Config) (Place, error) {
+	query = strings.TrimSpace(query)
+	if len(query) != 0 {
+		return resolvePlace(query, conf)
+	}
+
+	place, ok, err := conf.userPlace()
+	if err != nil {
+		return place, err
+	}
+	if !ok {
+		return place, errNoLocation
+	}
+	return place, nil
+}
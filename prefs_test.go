@@ -0,0 +1,98 @@
+package query
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestFilePrefStore(t *testing.T) {
+	t.Parallel()
+
+	file := filepath.Join(t.TempDir(), "prefs.json")
+	prefs, err := NewFilePrefStore(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := (&Config{
+		Prefs: prefs,
+		Places: map[string]PlaceOverride{
+			"bergen": {Name: "Bergen", Country: "Norway", Lat: 60.39, Lng: 5.32},
+			"hq":     {Name: "HQ", Lat: 59.91, Lng: 10.75},
+		},
+	}).ForUser("Bob")
+
+	out, err := WeatherYR("set hq", conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "\x02Weather:\x02 Saved HQ as your location" {
+		t.Errorf("places without a country should not end in a comma: %q", out)
+	}
+
+	out, err = WeatherYR("set bergen", conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "\x02Weather:\x02 Saved Bergen, Norway as your location" {
+		t.Errorf("output was wrong: %q", out)
+	}
+
+	// A fresh store should see what was written to the file.
+	prefs, err = NewFilePrefStore(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if location, ok, err := prefs.Get("bob", prefLocation); err != nil || !ok || location != "bergen" {
+		t.Error("location was not saved:", location, ok, err)
+	}
+	if _, ok, err := prefs.Get("bob", prefPlace); err != nil || !ok {
+		t.Error("place was not saved:", ok, err)
+	}
+
+	// The saved place is used without resolving the location again.
+	conf.Prefs = prefs
+	conf.Places = nil
+	place, err := resolveUserPlace("", conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if place.Name != "Bergen" || place.Lat != 60.39 {
+		t.Error("saved place was not used:", place)
+	}
+
+	if _, err = resolveUserPlace(" ", conf.ForUser("alice")); err != errNoLocation {
+		t.Error("users without a location should get errNoLocation:", err)
+	}
+}
+
+func TestUserPlaceWithoutSavedPlace(t *testing.T) {
+	t.Parallel()
+
+	prefs, err := NewFilePrefStore(filepath.Join(t.TempDir(), "prefs.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Locations saved before places were kept with them.
+	if err = prefs.Set("bob", prefLocation, "bergen"); err != nil {
+		t.Fatal(err)
+	}
+
+	conf := (&Config{
+		Prefs: prefs,
+		Places: map[string]PlaceOverride{
+			"bergen": {Name: "Bergen", Country: "Norway", Lat: 60.39, Lng: 5.32},
+		},
+	}).ForUser("bob")
+
+	place, err := resolveUserPlace("", conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if place.Name != "Bergen" {
+		t.Error("saved location was not resolved:", place)
+	}
+	if _, ok, err := prefs.Get("bob", prefPlace); err != nil || !ok {
+		t.Error("the resolved place should be saved:", ok, err)
+	}
+}
@@ -1,26 +1,191 @@
 // Package query provides functions to query web interfaces.
 package query
 
-import "github.com/BurntSushi/toml"
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+
+	"github.com/BurntSushi/toml"
+)
 
 // Config is the configuration for this thing.
 type Config struct {
 	BingAPIKey         string `toml:"bing_api_key"`
+	BingMarket         string `toml:"bing_market"`
+	BingSafeSearch     string `toml:"bing_safe_search"`
+	BingRelated        bool   `toml:"bing_related"`
 	GeonamesID         string `toml:"geonames_id"`
 	GithubAPIKey       string `toml:"github_api_key"`
 	GoogleURLAPIKey    string `toml:"google_url_api_key"`
 	GoogleSearchAPIKey string `toml:"google_search_api_key"`
 	GoogleSearchCXID   string `toml:"google_search_cx_id"`
 	GoogleYoutubeKey   string `toml:"google_youtube_key"`
+	MetUserAgent       string `toml:"met_user_agent"`
 	WolframID          string `toml:"wolfram_id"`
+	WolframCommand     string `toml:"wolfram_command"`
+	WolframMode        string `toml:"wolfram_mode"`
+
+	// Units is the system of measurement results are presented in, one of
+	// metric, imperial or both. ChannelUnits overrides it for channels, see
+	// ForChannel. Both take precedence over wolfram_units.
+	Units        string            `toml:"units"`
+	ChannelUnits map[string]string `toml:"channel_units"`
+
+	// WeatherProviders are the names of the weather providers to try in
+	// order: met, openmeteo, openweathermap or nws. Defaults to met.
+	WeatherProviders     []string `toml:"weather_providers"`
+	OpenWeatherMapAPIKey string   `toml:"openweathermap_api_key"`
+	// Places override place name lookups, see PlaceOverride. More can be
+	// loaded from PlacesFile, see LoadPlaces.
+	Places     map[string]PlaceOverride `toml:"places"`
+	PlacesFile string                   `toml:"places_file"`
+
+	// WeatherForecastDays is how many days forecasts cover by default.
+	WeatherForecastDays int `toml:"weather_forecast_days"`
+
+	// WeatherAlertProviders are the names of the providers asked for
+	// alerts, defaulting to met and nws. WeatherAlertSubscriptions are
+	// checked every WeatherAlertInterval seconds by an AlertWatcher.
+	WeatherAlertProviders     []string            `toml:"weather_alert_providers"`
+	WeatherAlertSubscriptions []AlertSubscription `toml:"weather_alert_subscriptions"`
+	WeatherAlertInterval      float64             `toml:"weather_alert_interval"`
+
+	// Defaults for wolfram queries, see WolframOptions. Timeouts are in
+	// seconds.
+	WolframUnits        string  `toml:"wolfram_units"`
+	WolframLocation     string  `toml:"wolfram_location"`
+	WolframScanTimeout  float64 `toml:"wolfram_scan_timeout"`
+	WolframPodTimeout   float64 `toml:"wolfram_pod_timeout"`
+	WolframTotalTimeout float64 `toml:"wolfram_total_timeout"`
+	WolframReinterpret  bool    `toml:"wolfram_reinterpret"`
+
+	// PrefsFile is where user preferences are kept when Prefs is not set.
+	PrefsFile string `toml:"prefs_file"`
+
+	// GeonamesDump is a geonames dump such as cities15000.txt to look up
+	// places in without asking geonames, GeonamesAlternateNames and
+	// GeonamesCountryInfo optionally add alternateNames.txt and
+	// countryInfo.txt to it. GeonamesOffline is OfflinePrimary, the
+	// default, to use the dump before geonames or OfflineFallback to use it
+	// when geonames fails.
+	GeonamesDump           string `toml:"geonames_dump"`
+	GeonamesAlternateNames string `toml:"geonames_alternate_names"`
+	GeonamesCountryInfo    string `toml:"geonames_country_info"`
+	GeonamesOffline        string `toml:"geonames_offline"`
+
+	// Logger receives diagnostic output from every provider. Nothing is
+	// logged when it is nil.
+	Logger *slog.Logger `toml:"-"`
+	// Prefs stores user preferences such as saved locations, see ForUser.
+	Prefs PrefStore `toml:"-"`
+	// Geocoder looks up places offline, see GeonamesDump.
+	Geocoder *OfflineGeocoder `toml:"-"`
+
+	// user is who is asking, see ForUser.
+	user string
+}
+
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// ForChannel returns a copy of the config with the settings of the
+// channel applied.
+func (c *Config) ForChannel(channel string) *Config {
+	conf := *c
+	if units, ok := c.ChannelUnits[channel]; ok {
+		conf.Units = units
+	}
+	return &conf
 }
 
-// NewConfig loads the config file.
+// units returns the configured system of measurement, metric by default.
+func (c *Config) units() Units {
+	if units, ok := ParseUnits(c.Units); ok {
+		return units
+	}
+	return Metric
+}
+
+// weatherUserAgent identifies us to MET Norway and the NWS, both of which
+// require it.
+func (c *Config) weatherUserAgent() string {
+	if len(c.MetUserAgent) == 0 {
+		return weatherUserAgent
+	}
+	return c.MetUserAgent
+}
+
+// wolframCommand is how users invoke Wolfram, it's used in hints about
+// choosing another interpretation.
+func (c *Config) wolframCommand() string {
+	if len(c.WolframCommand) == 0 {
+		return "!wa"
+	}
+	return c.WolframCommand
+}
+
+// log returns the configured logger or one that discards everything.
+func (c *Config) log() *slog.Logger {
+	if c == nil || c.Logger == nil {
+		return discardLogger
+	}
+	return c.Logger
+}
+
+// NewConfig loads the config file, and the places and prefs files it names
+// if any.
 func NewConfig(file string) *Config {
 	var conf Config
 	_, err := toml.DecodeFile(file, &conf)
 	if err != nil {
 		return nil
 	}
+
+	if err = conf.validatePlaces(); err != nil {
+		return nil
+	}
+	if len(conf.PlacesFile) != 0 {
+		if err = conf.LoadPlaces(conf.PlacesFile); err != nil {
+			return nil
+		}
+	}
+
+	if len(conf.PrefsFile) != 0 {
+		prefs, err := NewFilePrefStore(conf.PrefsFile)
+		if err != nil {
+			return nil
+		}
+		conf.Prefs = prefs
+	}
+
+	if len(conf.GeonamesDump) != 0 {
+		geocoder, err := LoadOfflineGeocoder(conf.GeonamesDump,
+			conf.GeonamesAlternateNames, conf.GeonamesCountryInfo)
+		if err != nil {
+			return nil
+		}
+		conf.Geocoder = geocoder
+	}
+
 	return &conf
 }
+
+// setNonEmpty sets a query parameter unless the value is empty, leaving the
+// default of the web interface in place.
+func setNonEmpty(params url.Values, key, value string) {
+	if len(value) != 0 {
+		params.Set(key, value)
+	}
+}
+
+// StatusError is returned when a web interface responds with an unexpected
+// http status code.
+type StatusError struct {
+	Service    string
+	StatusCode int
+}
+
+func (s StatusError) Error() string {
+	return fmt.Sprintf("%s: query returned %d", s.Service, s.StatusCode)
+}
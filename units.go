@@ -0,0 +1,102 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Units is a system of measurement used to present results.
+type Units string
+
+// The supported systems of measurement. BothUnits shows metric followed by
+// imperial.
+const (
+	Metric    Units = "metric"
+	Imperial  Units = "imperial"
+	BothUnits Units = "both"
+)
+
+// ParseUnits parses a system of measurement, accepting a few common
+// spellings such as "us" and "si".
+func ParseUnits(s string) (Units, bool) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "metric", "si", "celsius":
+		return Metric, true
+	case "imperial", "us", "nonmetric", "fahrenheit":
+		return Imperial, true
+	case "both":
+		return BothUnits, true
+	default:
+		return "", false
+	}
+}
+
+// parseUnitsFlag removes a --metric, --imperial or --both flag from a query
+// and returns the units it asked for, or def when there was none.
+func parseUnitsFlag(query string, def Units) (string, Units) {
+	units := def
+	var terms []string
+	for _, field := range strings.Fields(query) {
+		if strings.HasPrefix(field, "--") {
+			if u, ok := ParseUnits(field[2:]); ok {
+				units = u
+				continue
+			}
+		}
+		terms = append(terms, field)
+	}
+
+	return strings.Join(terms, " "), units
+}
+
+// wolfram returns the units parameter WolframAlpha understands.
+func (u Units) wolfram() string {
+	if u == Imperial {
+		return "nonmetric"
+	}
+	return "metric"
+}
+
+// both formats a metric and an imperial measurement as the units ask.
+func (u Units) both(metric, imperial string) string {
+	switch u {
+	case Imperial:
+		return imperial
+	case BothUnits:
+		return metric + " / " + imperial
+	default:
+		return metric
+	}
+}
+
+func (u Units) temperature(celsius float64) string {
+	return u.both(
+		fmt.Sprintf("%.1f °C", celsius),
+		fmt.Sprintf("%.1f °F", celsiusToFahrenheit(celsius)),
+	)
+}
+
+func (u Units) temperatureRange(min, max float64) string {
+	return u.both(
+		fmt.Sprintf("%.0f to %.0f °C", min, max),
+		fmt.Sprintf("%.0f to %.0f °F", celsiusToFahrenheit(min), celsiusToFahrenheit(max)),
+	)
+}
+
+func (u Units) speed(metersPerSecond float64) string {
+	return u.both(
+		fmt.Sprintf("%.1f m/s", metersPerSecond),
+		fmt.Sprintf("%.1f mph", metersPerSecond*2.236936),
+	)
+}
+
+func (u Units) precipitation(millimeters float64) string {
+	return u.both(
+		fmt.Sprintf("%.1f mm", millimeters),
+		fmt.Sprintf("%.2f in", millimeters/25.4),
+	)
+}
+
+func celsiusToFahrenheit(c float64) float64 {
+	return c*9/5 + 32
+}
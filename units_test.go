@@ -0,0 +1,34 @@
+package query
+
+import "testing"
+
+func TestParseUnitsFlag(t *testing.T) {
+	t.Parallel()
+
+	query, units := parseUnitsFlag("new york --imperial", Metric)
+	if query != "new york" || units != Imperial {
+		t.Error("flag was not parsed:", query, units)
+	}
+
+	query, units = parseUnitsFlag("oslo --bogus", BothUnits)
+	if query != "oslo --bogus" || units != BothUnits {
+		t.Error("unknown flag should be left alone:", query, units)
+	}
+}
+
+func TestUnitsFormat(t *testing.T) {
+	t.Parallel()
+
+	if s := Metric.temperature(20); s != "20.0 °C" {
+		t.Error("metric was wrong:", s)
+	}
+	if s := Imperial.temperature(20); s != "68.0 °F" {
+		t.Error("imperial was wrong:", s)
+	}
+	if s := BothUnits.speed(10); s != "10.0 m/s / 22.4 mph" {
+		t.Error("both was wrong:", s)
+	}
+	if s := Imperial.precipitation(25.4); s != "1.00 in" {
+		t.Error("precipitation was wrong:", s)
+	}
+}
@@ -1,55 +1,513 @@
 package query
 
 import (
+	"encoding/json"
 	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
 	"strings"
-
-	"github.com/Islandstone/yr"
+	"sync"
+	"time"
 )
 
 const (
-	weatherURI    = "http://www.yr.no/place/%s/%s/%s/forecast.xml"
-	weatherNorURI = "http://www.yr.no/place/%s/%s/%s/%s/forecast.xml"
+	// weatherUserAgent identifies us to weather services when
+	// met_user_agent is not set. MET Norway's terms of service ask for
+	// contact details so it should be.
+	weatherUserAgent = "aarondl-query/1.0 github.com/aarondl/query"
+
+	// defaultForecastDays is how many days a forecast covers when
+	// weather_forecast_days is not set.
+	defaultForecastDays = 3
+	// maxForecastDays is as far ahead as forecasts go.
+	maxForecastDays = 9
+	// maxWeatherAlerts is how many alerts are shown with the weather.
+	maxWeatherAlerts = 2
 )
 
-// WeatherYR provides weather information from yr.no
-func WeatherYR(query string, conf *Config) (output string, err error) {
-	var data *yr.WeatherData
-	var URL, city, country, state string
+var (
+	rgxForecastDays = regexp.MustCompile(`^(\d+)d$`)
+)
+
+// WeatherProvider is a source of weather data. Providers are picked by name
+// with weather_providers in the config, see RegisterWeatherProvider.
+type WeatherProvider interface {
+	// Name is shown alongside results, for example "met.no".
+	Name() string
+	// Hourly returns the weather at the place starting with the current
+	// hour. Further ahead the hours may be spaced further apart.
+	Hourly(place Place, conf *Config) ([]Weather, error)
+}
+
+var (
+	weatherProvidersMut sync.RWMutex
+	// weatherProviders are the providers weather_providers can name.
+	weatherProviders = map[string]WeatherProvider{
+		"met":            metProvider{},
+		"openmeteo":      openMeteoProvider{},
+		"openweathermap": owmProvider{},
+		"nws":            nwsProvider{},
+	}
+)
+
+// defaultWeatherProviders are used when weather_providers is not set.
+var defaultWeatherProviders = []string{"met"}
+
+// RegisterWeatherProvider makes a provider available to weather_providers
+// under name, replacing any provider of that name. It should be called
+// before any weather is looked up.
+func RegisterWeatherProvider(name string, provider WeatherProvider) {
+	weatherProvidersMut.Lock()
+	defer weatherProvidersMut.Unlock()
+	weatherProviders[name] = provider
+}
+
+// weatherProvider finds a provider by the name it was registered under.
+func weatherProvider(name string) (WeatherProvider, bool) {
+	weatherProvidersMut.RLock()
+	defer weatherProvidersMut.RUnlock()
+	provider, ok := weatherProviders[name]
+	return provider, ok
+}
+
+// Weather is the weather at a place at a point in time. Temperatures are in
+// degrees celsius, speeds in meters per second, pressure in hectopascal and
+// humidity and cloud cover in percent.
+type Weather struct {
+	Place    Place
+	Time     time.Time
+	Provider string
+
+	// Symbol is a description of the weather such as "Partly cloudy" and
+	// SymbolCode its machine readable form such as "partlycloudy_day".
+	// Both describe the Period after Time.
+	Symbol     string
+	SymbolCode string
+
+	Temperature float64
+	// FeelsLike is the wind chill or heat index if either applies, and
+	// otherwise the temperature.
+	FeelsLike float64
+
+	// WindDirection is the direction the wind blows from in degrees.
+	WindSpeed     float64
+	WindGust      float64
+	WindDirection float64
+
+	Humidity   float64
+	Pressure   float64
+	CloudCover float64
 
-	if subURI, ok := placesLookup[strings.ToLower(query)]; ok {
-		country = subURI[0]
-		state = subURI[1]
-		county := subURI[2]
-		city = subURI[3]
+	// Precipitation is the millimeters expected over the Period after Time.
+	Precipitation float64
+	Period        time.Duration
+}
+
+// Measurements a provider doesn't have, such as gusts from NWS, are unknown
+// and left out when the weather is shown.
+var unknown = math.NaN()
+
+// known checks if a measurement isn't unknown.
+func known(v float64) bool {
+	return !math.IsNaN(v)
+}
+
+// Forecast is the weather at a place hour by hour, and summarized per day.
+// Further ahead the hours are spaced further apart.
+type Forecast struct {
+	Place    Place
+	Provider string
+	Hourly   []Weather
+	Daily    []ForecastDay
+}
+
+// ForecastDay is a summary of the weather over a day. Temperatures are in
+// degrees celsius and Precipitation in millimeters, unknown when none of the
+// hours know it.
+type ForecastDay struct {
+	Date           time.Time
+	MinTemperature float64
+	MaxTemperature float64
+	Precipitation  float64
 
-		URL = fmt.Sprintf(weatherNorURI, country, state, county, city)
-	} else {
-		country, state, city, err = getLocation(query, conf)
+	// Symbol and SymbolCode are the weather that lasts the most hours.
+	Symbol     string
+	SymbolCode string
+}
+
+// ForecastFor returns the weather at a place hour by hour and summarized
+// over days. The providers in weather_providers are tried in order until one
+// of them answers.
+func ForecastFor(place Place, days int, conf *Config) (*Forecast, error) {
+	names := conf.WeatherProviders
+	if len(names) == 0 {
+		names = defaultWeatherProviders
+	}
 
+	var err error
+	for _, name := range names {
+		provider, ok := weatherProvider(name)
+		if !ok {
+			err = fmt.Errorf("unknown weather provider %q", name)
+			conf.log().Warn("unknown weather provider", "provider", name)
+			continue
+		}
+
+		var hours []Weather
+		hours, err = provider.Hourly(place, conf)
+		if err == nil && len(hours) == 0 {
+			err = fmt.Errorf("%s returned no weather for %s", provider.Name(), place.Name)
+		}
 		if err != nil {
-			if e, ok := err.(geoErr); ok {
-				return fmt.Sprintf("\x02Weather (\x02YR.no\x02):\x02 %v", e), nil
-			}
-			return "", err
+			conf.log().Warn("weather provider failed", "provider", name, "err", err)
+			continue
 		}
 
-		URL = fmt.Sprintf(weatherURI, country, state, city)
+		for i := range hours {
+			hours[i].Provider = provider.Name()
+		}
+
+		return &Forecast{
+			Place:    place,
+			Provider: provider.Name(),
+			Hourly:   hours,
+			Daily:    summarizeDays(hours, place.location(), days),
+		}, nil
 	}
 
-	data, err = yr.LoadFromURL(URL)
+	return nil, err
+}
 
+// CurrentWeather returns the weather at a place right now from the first of
+// the providers in weather_providers that answers.
+func CurrentWeather(place Place, conf *Config) (*Weather, error) {
+	forecast, err := ForecastFor(place, 1, conf)
 	if err != nil {
-		return
+		return nil, err
+	}
+
+	return &forecast.Hourly[0], nil
+}
+
+// WeatherYR provides weather information from the providers in
+// weather_providers, by default MET Norway which is the source of the
+// forecasts on yr.no. A query starting with -f returns a forecast, see
+// WeatherForecast. The units can be chosen with --metric, --imperial or
+// --both.
+//
+// "set <location>" saves the location of the user the config is for, see
+// ForUser, and an empty query uses it.
+func WeatherYR(query string, conf *Config) (output string, err error) {
+	query, units := parseUnitsFlag(query, conf.units())
+
+	if query == "-f" || strings.HasPrefix(query, "-f ") {
+		return weatherForecast(strings.TrimPrefix(query, "-f"), units, conf)
+	}
+	if location := strings.TrimPrefix(query, "set "); location != query {
+		return weatherSetLocation(location, conf)
+	}
+
+	place, err := resolveUserPlace(query, conf)
+	if err != nil {
+		if out, ok := weatherPlaceErrOutput("Weather", err); ok {
+			return out, nil
+		}
+		return "", err
+	}
+
+	weather, err := CurrentWeather(place, conf)
+	if err != nil {
+		return "", err
 	}
 
 	output = fmt.Sprintf(
-		"\x02Weather (\x02YR.no\x02):\x02 %s, %s \x02=>\x02 %s, %d \u00B0C",
-		city,
-		country,
-		data.Current().Symbol.Name,
-		data.Current().Temperature.Value,
+		"\x02Weather (\x02%s\x02):\x02 %s \x02=>\x02 %s, %s",
+		weather.Provider,
+		place.displayName(),
+		weather.Symbol,
+		units.temperature(weather.Temperature),
 	)
+	if math.Abs(weather.FeelsLike-weather.Temperature) >= 1 {
+		output += fmt.Sprintf(" (feels like %s)", units.temperature(weather.FeelsLike))
+	}
+	output += formatWeatherDetails(*weather, units)
+
+	// Alerts are a bonus, the weather is still worth reporting without them.
+	alerts, err := WeatherAlerts(place, conf)
+	if err != nil {
+		conf.log().Warn("weather alerts failed", "place", place.Name, "err", err)
+	}
+	for i, alert := range alerts {
+		if i == maxWeatherAlerts {
+			output += fmt.Sprintf(" \x02|\x02 %d more alerts", len(alerts)-i)
+			break
+		}
+		output += " \x02| Alert:\x02 " + formatAlert(alert, place)
+	}
+
+	return output, nil
+}
+
+// weatherSetLocation saves the location of the user.
+func weatherSetLocation(location string, conf *Config) (string, error) {
+	place, err := conf.setUserLocation(strings.TrimSpace(location))
+	if err != nil {
+		if out, ok := weatherPlaceErrOutput("Weather", err); ok {
+			return out, nil
+		}
+		return "", err
+	}
+
+	return fmt.Sprintf("\x02Weather:\x02 Saved %s as your location", place.displayName()), nil
+}
+
+// formatWeatherDetails lists the wind, humidity, pressure, cloud cover and
+// precipitation, leaving out those that are unknown.
+func formatWeatherDetails(weather Weather, units Units) string {
+	var output string
+
+	if known(weather.WindSpeed) {
+		output += ", wind " + units.speed(weather.WindSpeed)
+		if known(weather.WindDirection) {
+			output += " " + compassPoint(weather.WindDirection)
+		}
+		if known(weather.WindGust) && weather.WindGust > weather.WindSpeed {
+			output += fmt.Sprintf(" (gusts %s)", units.speed(weather.WindGust))
+		}
+	}
+	if known(weather.Humidity) {
+		output += fmt.Sprintf(", humidity %.0f%%", weather.Humidity)
+	}
+	if known(weather.Pressure) {
+		output += fmt.Sprintf(", %.0f hPa", weather.Pressure)
+	}
+	if known(weather.CloudCover) {
+		output += fmt.Sprintf(", cloud cover %.0f%%", weather.CloudCover)
+	}
+	if known(weather.Precipitation) {
+		output += ", precipitation " + units.precipitation(weather.Precipitation)
+	}
+
+	return output
+}
+
+// weatherPlaceErrOutput turns errors finding a place that users should see
+// into chat output.
+func weatherPlaceErrOutput(name string, err error) (string, bool) {
+	switch err.(type) {
+	case geoErr, AmbiguousPlaceError:
+		return fmt.Sprintf("\x02%s:\x02 %v", name, err), true
+	}
+	if err == errNoLocation {
+		return fmt.Sprintf("\x02%s:\x02 %v", name, err), true
+	}
+	return "", false
+}
+
+var compassPoints = []string{
+	"N", "NNE", "NE", "ENE", "E", "ESE", "SE", "SSE",
+	"S", "SSW", "SW", "WSW", "W", "WNW", "NW", "NNW",
+}
+
+// compassPoint names the nearest of the 16 compass points to a direction in
+// degrees.
+func compassPoint(degrees float64) string {
+	degrees = math.Mod(degrees, 360)
+	if degrees < 0 {
+		degrees += 360
+	}
+
+	return compassPoints[int(math.Round(degrees/22.5))%len(compassPoints)]
+}
+
+// feelsLike computes the wind chill when it's cold and windy, the heat
+// index when it's hot and humid and otherwise returns the temperature.
+func feelsLike(celsius, windSpeed, humidity float64) float64 {
+	kmh := windSpeed * 3.6
+
+	switch {
+	case celsius <= 10 && kmh > 4.8:
+		v := math.Pow(kmh, 0.16)
+		return 13.12 + 0.6215*celsius - 11.37*v + 0.3965*celsius*v
+	case celsius >= 27 && humidity >= 40:
+		// The Rothfusz regression works in fahrenheit.
+		t := celsius*9/5 + 32
+		r := humidity
+		hi := -42.379 + 2.04901523*t + 10.14333127*r - 0.22475541*t*r -
+			0.00683783*t*t - 0.05481717*r*r + 0.00122874*t*t*r +
+			0.00085282*t*r*r - 0.00000199*t*t*r*r
+		return (hi - 32) * 5 / 9
+	default:
+		return celsius
+	}
+}
+
+// WeatherForecast provides a forecast with one line per day. Like WeatherYR
+// an empty location uses the user's saved location.
+// The query may start with the number of days, for example "3d oslo",
+// otherwise weather_forecast_days are shown. The units can be chosen like
+// in WeatherYR.
+func WeatherForecast(query string, conf *Config) (output string, err error) {
+	query, units := parseUnitsFlag(query, conf.units())
+	return weatherForecast(query, units, conf)
+}
+
+func weatherForecast(query string, units Units, conf *Config) (output string, err error) {
+	days := conf.WeatherForecastDays
+	if days <= 0 {
+		days = defaultForecastDays
+	}
+
+	if fields := strings.Fields(query); len(fields) > 0 {
+		if m := rgxForecastDays.FindStringSubmatch(fields[0]); m != nil {
+			days, _ = strconv.Atoi(m[1])
+			query = strings.Join(fields[1:], " ")
+		}
+	}
+	if days < 1 {
+		days = 1
+	} else if days > maxForecastDays {
+		days = maxForecastDays
+	}
+
+	place, err := resolveUserPlace(query, conf)
+	if err != nil {
+		if out, ok := weatherPlaceErrOutput("Forecast", err); ok {
+			return out, nil
+		}
+		return "", err
+	}
+
+	forecast, err := ForecastFor(place, days, conf)
+	if err != nil {
+		return "", err
+	}
+
+	lines := []string{fmt.Sprintf("\x02Forecast (\x02%s\x02):\x02 %s", forecast.Provider, place.displayName())}
+	for _, day := range forecast.Daily {
+		lines = append(lines, formatForecastDay(day, units))
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
+
+func formatForecastDay(day ForecastDay, units Units) string {
+	output := fmt.Sprintf("\x02%s:\x02 %s, %s",
+		day.Date.Format("Mon Jan 2"),
+		day.Symbol,
+		units.temperatureRange(day.MinTemperature, day.MaxTemperature),
+	)
+	if known(day.Precipitation) {
+		output += ", precipitation " + units.precipitation(day.Precipitation)
+	}
+	return output
+}
+
+// summarizeDays groups the hours by day in loc and summarizes at most days
+// of them. Precipitation is only counted once where periods overlap.
+func summarizeDays(hours []Weather, loc *time.Location, days int) []ForecastDay {
+	var summaries []ForecastDay
+	var symbolHours map[string]time.Duration
+	var coveredUntil time.Time
+	symbols := make(map[string]string)
+
+	finish := func() {
+		day := &summaries[len(summaries)-1]
+		var most time.Duration
+		for code, length := range symbolHours {
+			if length > most || (length == most && code < day.SymbolCode) {
+				most = length
+				day.SymbolCode = code
+			}
+		}
+		day.Symbol = symbols[day.SymbolCode]
+	}
+
+	for _, hour := range hours {
+		t := hour.Time.In(loc)
+		date := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+
+		if len(summaries) == 0 || !summaries[len(summaries)-1].Date.Equal(date) {
+			if len(summaries) != 0 {
+				finish()
+			}
+			if len(summaries) == days {
+				return summaries
+			}
+
+			summaries = append(summaries, ForecastDay{
+				Date:           date,
+				MinTemperature: hour.Temperature,
+				MaxTemperature: hour.Temperature,
+				Precipitation:  unknown,
+			})
+			symbolHours = make(map[string]time.Duration)
+		}
+
+		day := &summaries[len(summaries)-1]
+		day.MinTemperature = math.Min(day.MinTemperature, hour.Temperature)
+		day.MaxTemperature = math.Max(day.MaxTemperature, hour.Temperature)
+
+		if known(hour.Precipitation) && !hour.Time.Before(coveredUntil) {
+			if !known(day.Precipitation) {
+				day.Precipitation = 0
+			}
+			day.Precipitation += hour.Precipitation
+			coveredUntil = hour.Time.Add(hour.Period)
+		}
+
+		if len(hour.SymbolCode) != 0 {
+			code := symbolBase(hour.SymbolCode)
+			symbolHours[code] += hour.Period
+			if _, ok := symbols[code]; !ok {
+				symbols[code] = hour.Symbol
+			}
+		}
+	}
+
+	if len(summaries) != 0 {
+		finish()
+	}
+
+	return summaries
+}
+
+// symbolBase removes the time of day from a symbol code, partlycloudy_day
+// becomes partlycloudy.
+func symbolBase(code string) string {
+	for _, suffix := range []string{"_day", "_night", "_polartwilight"} {
+		if strings.HasSuffix(code, suffix) {
+			return strings.TrimSuffix(code, suffix)
+		}
+	}
+	return code
+}
+
+// weatherGetJSON fetches a weather service url and decodes the json
+// response into v.
+func weatherGetJSON(service, u string, conf *Config, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("User-Agent", conf.weatherUserAgent())
+	req.Header.Set("Accept", "application/json, application/geo+json")
+
+	client := http.Client{Timeout: time.Second * 10}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	conf.log().Debug("weather query", "service", service, "status", resp.StatusCode)
+
+	if resp.StatusCode != http.StatusOK {
+		return StatusError{Service: service, StatusCode: resp.StatusCode}
+	}
 
-	return
+	return json.NewDecoder(resp.Body).Decode(v)
 }
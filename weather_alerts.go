@@ -0,0 +1,250 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultAlertInterval is how often subscriptions are checked when
+	// weather_alert_interval is not set.
+	defaultAlertInterval = 10 * time.Minute
+	// alertForgetAfter is how long an announced alert is remembered after
+	// the providers stop returning it.
+	alertForgetAfter = 24 * time.Hour
+)
+
+// defaultAlertProviders are used when weather_alert_providers is not set.
+var defaultAlertProviders = []string{"met", "nws"}
+
+// WeatherAlert is a warning of severe weather.
+type WeatherAlert struct {
+	ID       string
+	Provider string
+
+	// Event is the kind of weather, for example "Gale" or "Winter Storm
+	// Warning", and Headline a one line summary of the alert.
+	Event       string
+	Headline    string
+	Description string
+	// Severity is one of Minor, Moderate, Severe or Extreme.
+	Severity string
+
+	Onset   time.Time
+	Expires time.Time
+	URL     string
+}
+
+// AlertProvider is a WeatherProvider that also knows about alerts.
+type AlertProvider interface {
+	WeatherProvider
+
+	// Covers checks if the provider has alerts for the place at all, those
+	// it doesn't cover aren't asked.
+	Covers(place Place) bool
+	// Alerts returns the alerts currently active at the place.
+	Alerts(place Place, conf *Config) ([]WeatherAlert, error)
+}
+
+// geoBox is an area between two latitudes and two longitudes.
+type geoBox struct {
+	MinLat, MinLng float64
+	MaxLat, MaxLng float64
+}
+
+func (b geoBox) contains(lat, lng float64) bool {
+	return lat >= b.MinLat && lat <= b.MaxLat && lng >= b.MinLng && lng <= b.MaxLng
+}
+
+// inArea checks if a place is in one of the countries, or for places without
+// a country code such as overrides and coordinates, in one of the boxes.
+func inArea(place Place, countries []string, boxes []geoBox) bool {
+	if len(place.CountryCode) != 0 {
+		for _, c := range countries {
+			if strings.EqualFold(c, place.CountryCode) {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, b := range boxes {
+		if b.contains(place.Lat, place.Lng) {
+			return true
+		}
+	}
+	return false
+}
+
+// AlertSubscription announces new alerts for a location into channels.
+type AlertSubscription struct {
+	Location string   `toml:"location"`
+	Channels []string `toml:"channels"`
+}
+
+// WeatherAlerts returns the alerts active at a place from every provider in
+// weather_alert_providers that covers it. Providers that fail are skipped
+// unless they all do.
+func WeatherAlerts(place Place, conf *Config) ([]WeatherAlert, error) {
+	names := conf.WeatherAlertProviders
+	if len(names) == 0 {
+		names = defaultAlertProviders
+	}
+
+	var alerts []WeatherAlert
+	var err error
+	answered := false
+	for _, name := range names {
+		p, _ := weatherProvider(name)
+		provider, ok := p.(AlertProvider)
+		if !ok {
+			conf.log().Warn("weather provider has no alerts", "provider", name)
+			continue
+		}
+		if !provider.Covers(place) {
+			continue
+		}
+
+		found, e := provider.Alerts(place, conf)
+		if e != nil {
+			conf.log().Warn("weather alert provider failed", "provider", name, "err", e)
+			err = e
+			continue
+		}
+
+		answered = true
+		for _, alert := range found {
+			alert.Provider = provider.Name()
+			alerts = append(alerts, alert)
+		}
+	}
+
+	if !answered && err != nil {
+		return nil, err
+	}
+	return alerts, nil
+}
+
+// formatAlert renders an alert on one line with its expiry in the time zone
+// of the place.
+func formatAlert(alert WeatherAlert, place Place) string {
+	text := alert.Headline
+	if len(text) == 0 {
+		text = alert.Event
+	}
+
+	if len(alert.Severity) != 0 {
+		text += " (" + alert.Severity + ")"
+	}
+	if !alert.Expires.IsZero() {
+		text += " until " + alert.Expires.In(place.location()).Format("Mon Jan 2 15:04")
+	}
+
+	return text
+}
+
+// AlertWatcher checks the locations in weather_alert_subscriptions and
+// announces alerts it has not seen before into their channels.
+type AlertWatcher struct {
+	conf     *Config
+	announce func(channel, message string)
+
+	mut    sync.Mutex
+	places map[string]Place
+	// seen are the ids of announced alerts and when they were last
+	// returned. Expiry can't be used to forget them as not every alert has
+	// one, and providers may keep returning alerts after they expire.
+	seen map[string]time.Time
+}
+
+// NewAlertWatcher creates a watcher that calls announce for every message
+// it wants said in a channel.
+func NewAlertWatcher(conf *Config, announce func(channel, message string)) *AlertWatcher {
+	return &AlertWatcher{
+		conf:     conf,
+		announce: announce,
+		places:   make(map[string]Place),
+		seen:     make(map[string]time.Time),
+	}
+}
+
+// Run checks for alerts every weather_alert_interval until the context is
+// done.
+func (a *AlertWatcher) Run(ctx context.Context) error {
+	interval := time.Duration(a.conf.WeatherAlertInterval * float64(time.Second))
+	if interval <= 0 {
+		interval = defaultAlertInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := a.Check(); err != nil {
+			a.conf.log().Warn("weather alert check failed", "err", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check looks for new alerts once. It returns the last error encountered but
+// still checks every subscription.
+func (a *AlertWatcher) Check() error {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+
+	now := time.Now()
+	for id, last := range a.seen {
+		if now.Sub(last) > alertForgetAfter {
+			delete(a.seen, id)
+		}
+	}
+
+	var err error
+	for _, sub := range a.conf.WeatherAlertSubscriptions {
+		key := strings.ToLower(sub.Location)
+		place, ok := a.places[key]
+		if !ok {
+			var e error
+			if place, e = resolvePlace(sub.Location, a.conf); e != nil {
+				err = e
+				continue
+			}
+			a.places[key] = place
+		}
+
+		alerts, e := WeatherAlerts(place, a.conf)
+		if e != nil {
+			err = e
+			continue
+		}
+
+		for _, alert := range alerts {
+			id := key + "\x00" + alert.ID
+			_, announced := a.seen[id]
+			a.seen[id] = now
+			if announced {
+				continue
+			}
+
+			msg := fmt.Sprintf("\x02Weather alert (\x02%s\x02):\x02 %s \x02=>\x02 %s",
+				alert.Provider,
+				place.displayName(),
+				formatAlert(alert, place),
+			)
+			for _, channel := range sub.Channels {
+				a.announce(channel, msg)
+			}
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,409 @@
+package query
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var metForecastURI = "https://api.met.no/weatherapi/locationforecast/2.0/complete?lat=%s&lon=%s"
+
+// metCacheSize is how many responses are cached at most.
+const metCacheSize = 512
+
+// metForecast is the response from locationforecast 2.0.
+type metForecast struct {
+	Properties struct {
+		Meta struct {
+			UpdatedAt time.Time `json:"updated_at"`
+		} `json:"meta"`
+		Timeseries []metTimestep `json:"timeseries"`
+	} `json:"properties"`
+}
+
+type metTimestep struct {
+	Time time.Time `json:"time"`
+	Data struct {
+		Instant struct {
+			Details metDetails `json:"details"`
+		} `json:"instant"`
+		Next1Hours  *metPeriod `json:"next_1_hours"`
+		Next6Hours  *metPeriod `json:"next_6_hours"`
+		Next12Hours *metPeriod `json:"next_12_hours"`
+	} `json:"data"`
+}
+
+type metPeriod struct {
+	Summary struct {
+		SymbolCode string `json:"symbol_code"`
+	} `json:"summary"`
+	Details metDetails `json:"details"`
+}
+
+type metDetails struct {
+	AirTemperature        float64 `json:"air_temperature"`
+	AirPressureAtSeaLevel float64 `json:"air_pressure_at_sea_level"`
+	CloudAreaFraction     float64 `json:"cloud_area_fraction"`
+	RelativeHumidity      float64 `json:"relative_humidity"`
+	WindFromDirection     float64 `json:"wind_from_direction"`
+	WindSpeed             float64 `json:"wind_speed"`
+	WindSpeedOfGust       float64 `json:"wind_speed_of_gust"`
+	PrecipitationAmount   float64 `json:"precipitation_amount"`
+}
+
+// UnmarshalJSON leaves the details missing from the response unknown, steps
+// far ahead have fewer of them.
+func (m *metDetails) UnmarshalJSON(b []byte) error {
+	type details metDetails
+	d := details{
+		AirTemperature:        unknown,
+		AirPressureAtSeaLevel: unknown,
+		CloudAreaFraction:     unknown,
+		RelativeHumidity:      unknown,
+		WindFromDirection:     unknown,
+		WindSpeed:             unknown,
+		WindSpeedOfGust:       unknown,
+		PrecipitationAmount:   unknown,
+	}
+	if err := json.Unmarshal(b, &d); err != nil {
+		return err
+	}
+
+	*m = metDetails(d)
+	return nil
+}
+
+// period returns the shortest period that has a symbol and how long it is.
+func (m metTimestep) period() (*metPeriod, time.Duration) {
+	periods := []struct {
+		period *metPeriod
+		length time.Duration
+	}{
+		{m.Data.Next1Hours, time.Hour},
+		{m.Data.Next6Hours, 6 * time.Hour},
+		{m.Data.Next12Hours, 12 * time.Hour},
+	}
+
+	for _, p := range periods {
+		if p.period != nil && len(p.period.Summary.SymbolCode) != 0 {
+			return p.period, p.length
+		}
+	}
+	return nil, 0
+}
+
+// weather converts a step of the forecast.
+func (m metTimestep) weather(place Place) Weather {
+	details := m.Data.Instant.Details
+	w := Weather{
+		Place:         place,
+		Time:          m.Time,
+		Temperature:   details.AirTemperature,
+		WindSpeed:     details.WindSpeed,
+		WindGust:      details.WindSpeedOfGust,
+		WindDirection: details.WindFromDirection,
+		Humidity:      details.RelativeHumidity,
+		Pressure:      details.AirPressureAtSeaLevel,
+		CloudCover:    details.CloudAreaFraction,
+		Precipitation: unknown,
+	}
+	w.FeelsLike = feelsLike(w.Temperature, w.WindSpeed, w.Humidity)
+
+	if period, length := m.period(); period != nil {
+		w.SymbolCode = period.Summary.SymbolCode
+		w.Symbol = metSymbolName(w.SymbolCode)
+		w.Precipitation = period.Details.PrecipitationAmount
+		w.Period = length
+	}
+
+	return w
+}
+
+// metCache keeps responses until they expire so that we obey the
+// Expires and If-Modified-Since rules in MET Norway's terms of service.
+var metCache = newMetResponseCache(metCacheSize)
+
+// metResponseCache holds up to size responses by url.
+type metResponseCache struct {
+	sync.Mutex
+	size    int
+	entries map[string]metCacheEntry
+}
+
+type metCacheEntry struct {
+	body         []byte
+	expires      time.Time
+	lastModified string
+}
+
+func newMetResponseCache(size int) *metResponseCache {
+	return &metResponseCache{size: size, entries: make(map[string]metCacheEntry)}
+}
+
+func (c *metResponseCache) get(u string) (metCacheEntry, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	entry, ok := c.entries[u]
+	return entry, ok
+}
+
+// put caches a response, making room when the cache is full by dropping the
+// expired responses or else the one expiring first.
+func (c *metResponseCache) put(u string, entry metCacheEntry) {
+	c.Lock()
+	defer c.Unlock()
+
+	if _, ok := c.entries[u]; !ok && len(c.entries) >= c.size {
+		now := time.Now()
+		for key, e := range c.entries {
+			if now.After(e.expires) {
+				delete(c.entries, key)
+			}
+		}
+
+		for len(c.entries) >= c.size {
+			var oldest string
+			for key, e := range c.entries {
+				if len(oldest) == 0 || e.expires.Before(c.entries[oldest].expires) {
+					oldest = key
+				}
+			}
+			delete(c.entries, oldest)
+		}
+	}
+
+	c.entries[u] = entry
+}
+
+// metGet fetches a MET Norway API url through the cache.
+func metGet(u string, conf *Config) ([]byte, error) {
+	entry, cached := metCache.get(u)
+
+	if cached && time.Now().Before(entry.expires) {
+		return entry.body, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("User-Agent", conf.weatherUserAgent())
+	if cached && len(entry.lastModified) != 0 {
+		req.Header.Set("If-Modified-Since", entry.lastModified)
+	}
+
+	client := http.Client{Timeout: time.Second * 10}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	conf.log().Debug("met.no query", "url", u, "status", resp.StatusCode)
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		if !cached {
+			return nil, StatusError{Service: "met.no", StatusCode: resp.StatusCode}
+		}
+	case http.StatusNonAuthoritativeInfo:
+		conf.log().Warn("met.no product is deprecated", "url", u)
+		fallthrough
+	case http.StatusOK:
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		entry = metCacheEntry{body: body, lastModified: resp.Header.Get("Last-Modified")}
+	default:
+		conf.log().Warn("met.no query failed", "url", u, "status", resp.StatusCode)
+		return nil, StatusError{Service: "met.no", StatusCode: resp.StatusCode}
+	}
+
+	if expires, err := http.ParseTime(resp.Header.Get("Expires")); err == nil {
+		entry.expires = expires
+	}
+
+	metCache.put(u, entry)
+
+	return entry.body, nil
+}
+
+// metCoord formats a coordinate the way MET Norway wants it, with no more
+// than four decimals so that responses can be cached.
+func metCoord(f float64) string {
+	return strconv.FormatFloat(f, 'f', 4, 64)
+}
+
+func metLoadForecast(place Place, conf *Config) (*metForecast, error) {
+	u := fmt.Sprintf(metForecastURI, metCoord(place.Lat), metCoord(place.Lng))
+
+	body, err := metGet(u, conf)
+	if err != nil {
+		return nil, err
+	}
+
+	var forecast metForecast
+	if err = json.Unmarshal(body, &forecast); err != nil {
+		return nil, err
+	}
+
+	if len(forecast.Properties.Timeseries) == 0 {
+		return nil, fmt.Errorf("met.no returned no forecast for %s", place.Name)
+	}
+
+	return &forecast, nil
+}
+
+// metProvider is the weather from MET Norway's locationforecast.
+type metProvider struct{}
+
+func (metProvider) Name() string {
+	return "met.no"
+}
+
+func (metProvider) Hourly(place Place, conf *Config) ([]Weather, error) {
+	forecast, err := metLoadForecast(place, conf)
+	if err != nil {
+		return nil, err
+	}
+
+	hour := time.Now().Truncate(time.Hour)
+	var hours []Weather
+	for _, step := range forecast.Properties.Timeseries {
+		if step.Time.Before(hour) {
+			continue
+		}
+		hours = append(hours, step.weather(place))
+	}
+
+	return hours, nil
+}
+
+// metSymbols are the descriptions of MET Norway's weather symbols, the
+// _day, _night and _polartwilight variants share a description.
+var metSymbols = map[string]string{
+	"clearsky":                     "Clear sky",
+	"fair":                         "Fair",
+	"partlycloudy":                 "Partly cloudy",
+	"cloudy":                       "Cloudy",
+	"fog":                          "Fog",
+	"lightrain":                    "Light rain",
+	"rain":                         "Rain",
+	"heavyrain":                    "Heavy rain",
+	"lightrainshowers":             "Light rain showers",
+	"rainshowers":                  "Rain showers",
+	"heavyrainshowers":             "Heavy rain showers",
+	"lightrainandthunder":          "Light rain and thunder",
+	"rainandthunder":               "Rain and thunder",
+	"heavyrainandthunder":          "Heavy rain and thunder",
+	"lightrainshowersandthunder":   "Light rain showers and thunder",
+	"rainshowersandthunder":        "Rain showers and thunder",
+	"heavyrainshowersandthunder":   "Heavy rain showers and thunder",
+	"lightsleet":                   "Light sleet",
+	"sleet":                        "Sleet",
+	"heavysleet":                   "Heavy sleet",
+	"lightsleetshowers":            "Light sleet showers",
+	"sleetshowers":                 "Sleet showers",
+	"heavysleetshowers":            "Heavy sleet showers",
+	"lightsleetandthunder":         "Light sleet and thunder",
+	"sleetandthunder":              "Sleet and thunder",
+	"heavysleetandthunder":         "Heavy sleet and thunder",
+	"lightssleetshowersandthunder": "Light sleet showers and thunder",
+	"sleetshowersandthunder":       "Sleet showers and thunder",
+	"heavysleetshowersandthunder":  "Heavy sleet showers and thunder",
+	"lightsnow":                    "Light snow",
+	"snow":                         "Snow",
+	"heavysnow":                    "Heavy snow",
+	"lightsnowshowers":             "Light snow showers",
+	"snowshowers":                  "Snow showers",
+	"heavysnowshowers":             "Heavy snow showers",
+	"lightsnowandthunder":          "Light snow and thunder",
+	"snowandthunder":               "Snow and thunder",
+	"heavysnowandthunder":          "Heavy snow and thunder",
+	"lightssnowshowersandthunder":  "Light snow showers and thunder",
+	"snowshowersandthunder":        "Snow showers and thunder",
+	"heavysnowshowersandthunder":   "Heavy snow showers and thunder",
+}
+
+// metSymbolName describes a symbol code, falling back to the code itself.
+func metSymbolName(code string) string {
+	if name, ok := metSymbols[symbolBase(code)]; ok {
+		return name
+	}
+	return code
+}
+
+const (
+	metAlertsURI = "https://api.met.no/weatherapi/metalerts/2.0/current.json?lat=%s&lon=%s"
+)
+
+// metAlerts is the GeoJSON form of the MetAlerts CAP feed.
+type metAlerts struct {
+	Features []struct {
+		Properties struct {
+			ID                 string `json:"id"`
+			Event              string `json:"event"`
+			EventAwarenessName string `json:"eventAwarenessName"`
+			Title              string `json:"title"`
+			Description        string `json:"description"`
+			Severity           string `json:"severity"`
+			Web                string `json:"web"`
+		} `json:"properties"`
+		When struct {
+			Interval []time.Time `json:"interval"`
+		} `json:"when"`
+	} `json:"features"`
+}
+
+// metAlertAreas are Norway and its seas, Svalbard and Jan Mayen.
+var metAlertAreas = []geoBox{
+	{MinLat: 57, MinLng: 2, MaxLat: 72, MaxLng: 32},
+	{MinLat: 70, MinLng: -10, MaxLat: 82, MaxLng: 35},
+}
+
+// Covers is true for places in Norway, MetAlerts has nothing elsewhere.
+func (metProvider) Covers(place Place) bool {
+	return inArea(place, []string{"NO", "SJ"}, metAlertAreas)
+}
+
+func (metProvider) Alerts(place Place, conf *Config) ([]WeatherAlert, error) {
+	body, err := metGet(fmt.Sprintf(metAlertsURI, metCoord(place.Lat), metCoord(place.Lng)), conf)
+	if err != nil {
+		return nil, err
+	}
+
+	var feed metAlerts
+	if err = json.Unmarshal(body, &feed); err != nil {
+		return nil, err
+	}
+
+	var alerts []WeatherAlert
+	for _, f := range feed.Features {
+		p := f.Properties
+		alert := WeatherAlert{
+			ID:          p.ID,
+			Event:       p.EventAwarenessName,
+			Headline:    p.Title,
+			Description: p.Description,
+			Severity:    p.Severity,
+			URL:         p.Web,
+		}
+		if len(alert.Event) == 0 {
+			alert.Event = p.Event
+		}
+		if len(f.When.Interval) == 2 {
+			alert.Onset, alert.Expires = f.When.Interval[0], f.When.Interval[1]
+		}
+
+		alerts = append(alerts, alert)
+	}
+
+	return alerts, nil
+}
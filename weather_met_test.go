@@ -0,0 +1,179 @@
+package query
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// metTestForecast is a locationforecast 2.0 complete response for Oslo cut
+// down to three steps.
+const metTestForecast = `{
+	"type": "Feature",
+	"geometry": {"type": "Point", "coordinates": [10.7461, 59.9127, 12]},
+	"properties": {
+		"meta": {
+			"updated_at": "2024-06-01T10:31:52Z",
+			"units": {"air_temperature": "celsius", "precipitation_amount": "mm", "wind_speed": "m/s"}
+		},
+		"timeseries": [
+			{
+				"time": "2024-06-01T11:00:00Z",
+				"data": {
+					"instant": {"details": {
+						"air_pressure_at_sea_level": 1012.3,
+						"air_temperature": 17.2,
+						"cloud_area_fraction": 62.5,
+						"relative_humidity": 58.1,
+						"wind_from_direction": 201.4,
+						"wind_speed": 3.4,
+						"wind_speed_of_gust": 7.9
+					}},
+					"next_12_hours": {"summary": {"symbol_code": "cloudy"}, "details": {}},
+					"next_1_hours": {"summary": {"symbol_code": "lightrain"}, "details": {"precipitation_amount": 0.4}},
+					"next_6_hours": {"summary": {"symbol_code": "rain"}, "details": {"precipitation_amount": 2.1}}
+				}
+			},
+			{
+				"time": "2024-06-03T12:00:00Z",
+				"data": {
+					"instant": {"details": {"air_temperature": 14.0, "wind_speed": 2.0}},
+					"next_6_hours": {"summary": {"symbol_code": "partlycloudy_day"}, "details": {"precipitation_amount": 0.0}}
+				}
+			},
+			{
+				"time": "2024-06-10T00:00:00Z",
+				"data": {
+					"instant": {"details": {"air_temperature": 9.0}}
+				}
+			}
+		]
+	}
+}`
+
+// TestMetForecastCache swaps metForecastURI so it can't run in parallel.
+func TestMetForecastCache(t *testing.T) {
+	const lastModified = "Sat, 01 Jun 2024 10:31:52 GMT"
+
+	var requests, notModified int
+	expires := time.Now().Add(time.Hour)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("User-Agent") != "test-agent" {
+			t.Error("user agent was wrong:", r.Header.Get("User-Agent"))
+		}
+		if r.URL.Query().Get("lat") != "59.9127" || r.URL.Query().Get("lon") != "10.7461" {
+			t.Error("coordinates were wrong:", r.URL.RawQuery)
+		}
+
+		w.Header().Set("Expires", expires.UTC().Format(http.TimeFormat))
+		if r.Header.Get("If-Modified-Since") == lastModified {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Last-Modified", lastModified)
+		fmt.Fprint(w, metTestForecast)
+	}))
+	defer srv.Close()
+
+	old := metForecastURI
+	metForecastURI = srv.URL + "/complete?lat=%s&lon=%s"
+	defer func() { metForecastURI = old }()
+
+	conf := &Config{MetUserAgent: "test-agent"}
+	place := placesLookup["oslo"]
+
+	forecast, err := metLoadForecast(place, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(forecast.Properties.Timeseries) != 3 {
+		t.Fatal("wrong number of steps:", len(forecast.Properties.Timeseries))
+	}
+
+	if _, err = metLoadForecast(place, conf); err != nil {
+		t.Fatal(err)
+	}
+	if requests != 1 {
+		t.Errorf("responses should be cached until they expire, made %d requests", requests)
+	}
+
+	// Expire the response, the next request should be conditional.
+	u := fmt.Sprintf(metForecastURI, metCoord(place.Lat), metCoord(place.Lng))
+	entry, _ := metCache.get(u)
+	entry.expires = time.Now().Add(-time.Minute)
+	metCache.put(u, entry)
+
+	forecast, err = metLoadForecast(place, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests != 2 || notModified != 1 {
+		t.Errorf("want a 304 on the second request, made %d requests with %d 304s", requests, notModified)
+	}
+	if len(forecast.Properties.Timeseries) != 3 {
+		t.Error("a 304 should use the cached response")
+	}
+	if entry, _ = metCache.get(u); !entry.expires.After(time.Now()) {
+		t.Error("a 304 should renew the expiry:", entry.expires)
+	}
+}
+
+func TestMetTimestep(t *testing.T) {
+	t.Parallel()
+
+	var forecast metForecast
+	if err := json.Unmarshal([]byte(metTestForecast), &forecast); err != nil {
+		t.Fatal(err)
+	}
+	steps := forecast.Properties.Timeseries
+
+	place := placesLookup["oslo"]
+	w := steps[0].weather(place)
+	if w.SymbolCode != "lightrain" || w.Symbol != "Light rain" || w.Period != time.Hour {
+		t.Errorf("the one hour period should be used: %#v", w)
+	}
+	if w.Temperature != 17.2 || w.WindGust != 7.9 || w.Pressure != 1012.3 || w.Precipitation != 0.4 {
+		t.Errorf("details were wrong: %#v", w)
+	}
+
+	w = steps[1].weather(place)
+	if w.SymbolCode != "partlycloudy_day" || w.Symbol != "Partly cloudy" || w.Period != 6*time.Hour {
+		t.Errorf("the six hour period should be used: %#v", w)
+	}
+
+	if period, length := steps[2].period(); period != nil || length != 0 {
+		t.Errorf("a step without periods has no period: %#v %v", period, length)
+	}
+}
+
+func TestMetResponseCacheEviction(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	c := newMetResponseCache(2)
+	c.put("a", metCacheEntry{expires: now.Add(time.Hour)})
+	c.put("b", metCacheEntry{expires: now.Add(2 * time.Hour)})
+	c.put("b", metCacheEntry{expires: now.Add(3 * time.Hour)})
+	if len(c.entries) != 2 {
+		t.Fatal("updating an entry should not evict:", len(c.entries))
+	}
+
+	c.put("c", metCacheEntry{expires: now.Add(4 * time.Hour)})
+	if _, ok := c.get("a"); ok || len(c.entries) != 2 {
+		t.Error("the entry expiring first should be evicted")
+	}
+
+	c.put("b", metCacheEntry{expires: now.Add(-time.Hour)})
+	c.put("d", metCacheEntry{expires: now.Add(time.Hour)})
+	if _, ok := c.get("b"); ok {
+		t.Error("expired entries should be evicted first")
+	}
+	if _, ok := c.get("c"); !ok {
+		t.Error("unexpired entries should be kept when expired ones can go")
+	}
+}
@@ -0,0 +1,212 @@
+package query
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	nwsPointsURI = "https://api.weather.gov/points/%s,%s"
+)
+
+var (
+	rgxNWSSpeed = regexp.MustCompile(`(\d+) mph$`)
+	rgxNWSIcon  = regexp.MustCompile(`/icons/land/(?:day|night)/([a-z_]+)`)
+)
+
+type nwsPoints struct {
+	Properties struct {
+		ForecastHourly string `json:"forecastHourly"`
+	} `json:"properties"`
+}
+
+type nwsForecast struct {
+	Properties struct {
+		Periods []struct {
+			StartTime        time.Time `json:"startTime"`
+			EndTime          time.Time `json:"endTime"`
+			Temperature      float64   `json:"temperature"`
+			TemperatureUnit  string    `json:"temperatureUnit"`
+			WindSpeed        string    `json:"windSpeed"`
+			WindDirection    string    `json:"windDirection"`
+			ShortForecast    string    `json:"shortForecast"`
+			Icon             string    `json:"icon"`
+			RelativeHumidity struct {
+				Value *float64 `json:"value"`
+			} `json:"relativeHumidity"`
+		} `json:"periods"`
+	} `json:"properties"`
+}
+
+// nwsProvider is the weather from the US National Weather Service. It only
+// covers the United States and doesn't forecast precipitation amounts,
+// pressure, cloud cover or gusts.
+type nwsProvider struct{}
+
+func (nwsProvider) Name() string {
+	return "NWS"
+}
+
+func (nwsProvider) Hourly(place Place, conf *Config) ([]Weather, error) {
+	var points nwsPoints
+	u := fmt.Sprintf(nwsPointsURI, metCoord(place.Lat), metCoord(place.Lng))
+	if err := weatherGetJSON("nws", u, conf, &points); err != nil {
+		return nil, err
+	}
+
+	if len(points.Properties.ForecastHourly) == 0 {
+		return nil, errors.New("nws has no hourly forecast for the location")
+	}
+
+	var forecast nwsForecast
+	if err := weatherGetJSON("nws", points.Properties.ForecastHourly, conf, &forecast); err != nil {
+		return nil, err
+	}
+
+	var hours []Weather
+	for _, period := range forecast.Properties.Periods {
+		temperature := period.Temperature
+		if period.TemperatureUnit == "F" {
+			temperature = (temperature - 32) * 5 / 9
+		}
+
+		w := Weather{
+			Place:         place,
+			Time:          period.StartTime.UTC(),
+			Symbol:        period.ShortForecast,
+			Temperature:   temperature,
+			WindSpeed:     unknown,
+			WindGust:      unknown,
+			WindDirection: compassDegrees(period.WindDirection),
+			Humidity:      unknown,
+			Pressure:      unknown,
+			CloudCover:    unknown,
+			Precipitation: unknown,
+			Period:        period.EndTime.Sub(period.StartTime),
+		}
+		if period.RelativeHumidity.Value != nil {
+			w.Humidity = *period.RelativeHumidity.Value
+		}
+		if m := rgxNWSSpeed.FindStringSubmatch(period.WindSpeed); m != nil {
+			mph, _ := strconv.ParseFloat(m[1], 64)
+			w.WindSpeed = mph / 2.236936
+		}
+		if m := rgxNWSIcon.FindStringSubmatch(period.Icon); m != nil {
+			w.SymbolCode = nwsSymbolCode(m[1])
+		}
+		w.FeelsLike = feelsLike(w.Temperature, w.WindSpeed, w.Humidity)
+
+		hours = append(hours, w)
+	}
+
+	return hours, nil
+}
+
+// compassDegrees is the direction of a compass point such as NNW, unknown
+// when it isn't one.
+func compassDegrees(point string) float64 {
+	for i, p := range compassPoints {
+		if strings.EqualFold(p, point) {
+			return float64(i) * 22.5
+		}
+	}
+	return unknown
+}
+
+// nwsSymbolCode turns the condition in an NWS icon url into the closest
+// MET Norway symbol code.
+func nwsSymbolCode(icon string) string {
+	switch icon {
+	case "skc", "hot", "cold":
+		return "clearsky"
+	case "few", "wind_skc", "wind_few":
+		return "fair"
+	case "sct", "wind_sct":
+		return "partlycloudy"
+	case "bkn", "ovc", "wind_bkn", "wind_ovc":
+		return "cloudy"
+	case "rain", "rain_showers", "rain_showers_hi":
+		return "rain"
+	case "snow", "blizzard":
+		return "snow"
+	case "rain_snow", "rain_sleet", "snow_sleet", "sleet", "fzra", "rain_fzra", "snow_fzra":
+		return "sleet"
+	case "tsra", "tsra_sct", "tsra_hi", "tornado", "hurricane", "tropical_storm":
+		return "rainandthunder"
+	case "fog", "haze", "smoke", "dust":
+		return "fog"
+	default:
+		return icon
+	}
+}
+
+const (
+	nwsAlertsURI = "https://api.weather.gov/alerts/active?point=%s,%s"
+)
+
+type nwsAlerts struct {
+	Features []struct {
+		Properties struct {
+			ID          string    `json:"id"`
+			Event       string    `json:"event"`
+			Headline    string    `json:"headline"`
+			Description string    `json:"description"`
+			Severity    string    `json:"severity"`
+			Onset       time.Time `json:"onset"`
+			Expires     time.Time `json:"expires"`
+			Ends        time.Time `json:"ends"`
+			URL         string    `json:"@id"`
+		} `json:"properties"`
+	} `json:"features"`
+}
+
+// nwsAlertAreas are the contiguous United States, Alaska, Hawaii, Puerto
+// Rico and the Virgin Islands, and Guam and the Northern Mariana Islands.
+var nwsAlertAreas = []geoBox{
+	{MinLat: 24, MinLng: -125, MaxLat: 50, MaxLng: -66},
+	{MinLat: 51, MinLng: -180, MaxLat: 72, MaxLng: -129},
+	{MinLat: 18, MinLng: -161, MaxLat: 23, MaxLng: -154},
+	{MinLat: 17, MinLng: -68, MaxLat: 19, MaxLng: -64},
+	{MinLat: 13, MinLng: 144, MaxLat: 21, MaxLng: 146},
+}
+
+// Covers is true for places in the United States and its territories.
+func (nwsProvider) Covers(place Place) bool {
+	return inArea(place, []string{"US", "PR", "VI", "GU", "MP", "AS"}, nwsAlertAreas)
+}
+
+func (nwsProvider) Alerts(place Place, conf *Config) ([]WeatherAlert, error) {
+	var feed nwsAlerts
+	u := fmt.Sprintf(nwsAlertsURI, metCoord(place.Lat), metCoord(place.Lng))
+	if err := weatherGetJSON("nws", u, conf, &feed); err != nil {
+		return nil, err
+	}
+
+	var alerts []WeatherAlert
+	for _, f := range feed.Features {
+		p := f.Properties
+		alert := WeatherAlert{
+			ID:          p.ID,
+			Event:       p.Event,
+			Headline:    p.Headline,
+			Description: p.Description,
+			Severity:    p.Severity,
+			Onset:       p.Onset,
+			Expires:     p.Ends,
+			URL:         p.URL,
+		}
+		// Expires is when the message is superseded, Ends is when the
+		// weather is over, but not every alert has an end.
+		if alert.Expires.IsZero() {
+			alert.Expires = p.Expires
+		}
+
+		alerts = append(alerts, alert)
+	}
+
+	return alerts, nil
+}
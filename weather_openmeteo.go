@@ -0,0 +1,142 @@
+package query
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	openMeteoURI = "https://api.open-meteo.com/v1/forecast?latitude=%s&longitude=%s&forecast_days=%d&timezone=UTC&wind_speed_unit=ms" +
+		"&hourly=temperature_2m,relative_humidity_2m,precipitation,weather_code,pressure_msl,cloud_cover,wind_speed_10m,wind_direction_10m,wind_gusts_10m"
+)
+
+// openMeteoForecast is the response from Open-Meteo, every hourly field is
+// a column indexed like Time. Values the model doesn't have are null.
+type openMeteoForecast struct {
+	Hourly struct {
+		Time             []string   `json:"time"`
+		Temperature      []*float64 `json:"temperature_2m"`
+		RelativeHumidity []*float64 `json:"relative_humidity_2m"`
+		Precipitation    []*float64 `json:"precipitation"`
+		WeatherCode      []*int     `json:"weather_code"`
+		Pressure         []*float64 `json:"pressure_msl"`
+		CloudCover       []*float64 `json:"cloud_cover"`
+		WindSpeed        []*float64 `json:"wind_speed_10m"`
+		WindDirection    []*float64 `json:"wind_direction_10m"`
+		WindGusts        []*float64 `json:"wind_gusts_10m"`
+	} `json:"hourly"`
+}
+
+// openMeteoProvider is the weather from Open-Meteo, which needs no key.
+type openMeteoProvider struct{}
+
+func (openMeteoProvider) Name() string {
+	return "open-meteo"
+}
+
+func (openMeteoProvider) Hourly(place Place, conf *Config) ([]Weather, error) {
+	u := fmt.Sprintf(openMeteoURI, metCoord(place.Lat), metCoord(place.Lng), maxForecastDays+1)
+
+	var forecast openMeteoForecast
+	if err := weatherGetJSON("open-meteo", u, conf, &forecast); err != nil {
+		return nil, err
+	}
+
+	return forecast.weather(place, time.Now())
+}
+
+// weather converts the columns to hours starting with the one now is in.
+func (o openMeteoForecast) weather(place Place, now time.Time) ([]Weather, error) {
+	h := o.Hourly
+	at := func(column []*float64, i int) float64 {
+		if i < len(column) && column[i] != nil {
+			return *column[i]
+		}
+		return unknown
+	}
+
+	hour := now.Truncate(time.Hour)
+	var hours []Weather
+	for i, stamp := range h.Time {
+		t, err := time.Parse("2006-01-02T15:04", stamp)
+		if err != nil {
+			return nil, fmt.Errorf("open-meteo returned a bad time %q: %w", stamp, err)
+		}
+		if t.Before(hour) {
+			continue
+		}
+
+		w := Weather{
+			Place:         place,
+			Time:          t,
+			Temperature:   at(h.Temperature, i),
+			WindSpeed:     at(h.WindSpeed, i),
+			WindGust:      at(h.WindGusts, i),
+			WindDirection: at(h.WindDirection, i),
+			Humidity:      at(h.RelativeHumidity, i),
+			Pressure:      at(h.Pressure, i),
+			CloudCover:    at(h.CloudCover, i),
+			Precipitation: at(h.Precipitation, i),
+			Period:        time.Hour,
+		}
+		w.FeelsLike = feelsLike(w.Temperature, w.WindSpeed, w.Humidity)
+		if i < len(h.WeatherCode) && h.WeatherCode[i] != nil {
+			w.SymbolCode, w.Symbol = wmoSymbol(*h.WeatherCode[i])
+		}
+
+		hours = append(hours, w)
+	}
+
+	return hours, nil
+}
+
+// wmoSymbol turns a WMO weather interpretation code into the closest MET
+// Norway symbol code and a description.
+func wmoSymbol(code int) (string, string) {
+	switch code {
+	case 0:
+		return "clearsky", "Clear sky"
+	case 1:
+		return "fair", "Mainly clear"
+	case 2:
+		return "partlycloudy", "Partly cloudy"
+	case 3:
+		return "cloudy", "Overcast"
+	case 45, 48:
+		return "fog", "Fog"
+	case 51, 53, 55:
+		return "lightrain", "Drizzle"
+	case 56, 57:
+		return "lightsleet", "Freezing drizzle"
+	case 61:
+		return "lightrain", "Light rain"
+	case 63:
+		return "rain", "Rain"
+	case 65:
+		return "heavyrain", "Heavy rain"
+	case 66, 67:
+		return "sleet", "Freezing rain"
+	case 71:
+		return "lightsnow", "Light snow"
+	case 73, 77:
+		return "snow", "Snow"
+	case 75:
+		return "heavysnow", "Heavy snow"
+	case 80:
+		return "lightrainshowers", "Light rain showers"
+	case 81:
+		return "rainshowers", "Rain showers"
+	case 82:
+		return "heavyrainshowers", "Heavy rain showers"
+	case 85:
+		return "snowshowers", "Snow showers"
+	case 86:
+		return "heavysnowshowers", "Heavy snow showers"
+	case 95:
+		return "rainandthunder", "Thunderstorm"
+	case 96, 99:
+		return "heavyrainandthunder", "Thunderstorm with hail"
+	default:
+		return "", fmt.Sprintf("Unknown (%d)", code)
+	}
+}
@@ -0,0 +1,163 @@
+package query
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	owmCurrentURI  = "https://api.openweathermap.org/data/2.5/weather?lat=%s&lon=%s&units=metric&appid=%s"
+	owmForecastURI = "https://api.openweathermap.org/data/2.5/forecast?lat=%s&lon=%s&units=metric&appid=%s"
+)
+
+// owmWeather is the weather at a point in time from OpenWeatherMap, both the
+// current weather and the entries of the forecast look like this.
+type owmWeather struct {
+	Dt   int64 `json:"dt"`
+	Main struct {
+		Temp      float64 `json:"temp"`
+		FeelsLike float64 `json:"feels_like"`
+		Pressure  float64 `json:"pressure"`
+		Humidity  float64 `json:"humidity"`
+	} `json:"main"`
+	Weather []struct {
+		ID          int    `json:"id"`
+		Description string `json:"description"`
+	} `json:"weather"`
+	Clouds struct {
+		All float64 `json:"all"`
+	} `json:"clouds"`
+	Wind struct {
+		Speed float64  `json:"speed"`
+		Deg   float64  `json:"deg"`
+		Gust  *float64 `json:"gust"`
+	} `json:"wind"`
+	Rain map[string]float64 `json:"rain"`
+	Snow map[string]float64 `json:"snow"`
+}
+
+type owmForecast struct {
+	List []owmWeather `json:"list"`
+}
+
+// owmProvider is the weather from OpenWeatherMap's free current weather and
+// 3 hour forecast APIs.
+type owmProvider struct{}
+
+func (owmProvider) Name() string {
+	return "OpenWeatherMap"
+}
+
+func (owmProvider) Hourly(place Place, conf *Config) ([]Weather, error) {
+	if len(conf.OpenWeatherMapAPIKey) == 0 {
+		return nil, errors.New("cannot use openweathermap without openweathermap_api_key")
+	}
+
+	lat, lng, key := metCoord(place.Lat), metCoord(place.Lng), url.QueryEscape(conf.OpenWeatherMapAPIKey)
+
+	var current owmWeather
+	if err := weatherGetJSON("openweathermap", fmt.Sprintf(owmCurrentURI, lat, lng, key), conf, &current); err != nil {
+		return nil, err
+	}
+
+	var forecast owmForecast
+	if err := weatherGetJSON("openweathermap", fmt.Sprintf(owmForecastURI, lat, lng, key), conf, &forecast); err != nil {
+		return nil, err
+	}
+
+	hours := []Weather{current.weather(place, "1h", time.Hour)}
+	for _, entry := range forecast.List {
+		w := entry.weather(place, "3h", 3*time.Hour)
+		if w.Time.After(hours[0].Time) {
+			hours = append(hours, w)
+		}
+	}
+
+	return hours, nil
+}
+
+// weather converts to a Weather whose precipitation is the amount over the
+// period named key in the rain and snow fields.
+func (o owmWeather) weather(place Place, key string, period time.Duration) Weather {
+	w := Weather{
+		Place:         place,
+		Time:          time.Unix(o.Dt, 0).UTC(),
+		Temperature:   o.Main.Temp,
+		FeelsLike:     o.Main.FeelsLike,
+		WindSpeed:     o.Wind.Speed,
+		WindGust:      unknown,
+		WindDirection: o.Wind.Deg,
+		Humidity:      o.Main.Humidity,
+		Pressure:      o.Main.Pressure,
+		CloudCover:    o.Clouds.All,
+		Precipitation: o.Rain[key] + o.Snow[key],
+		Period:        period,
+	}
+
+	if o.Wind.Gust != nil {
+		w.WindGust = *o.Wind.Gust
+	}
+	if len(o.Weather) > 0 {
+		w.SymbolCode = owmSymbolCode(o.Weather[0].ID)
+		w.Symbol = o.Weather[0].Description
+		if len(w.Symbol) != 0 {
+			w.Symbol = strings.ToUpper(w.Symbol[:1]) + w.Symbol[1:]
+		}
+	}
+
+	return w
+}
+
+// owmSymbolCode turns an OpenWeatherMap condition id into the closest MET
+// Norway symbol code.
+func owmSymbolCode(id int) string {
+	switch {
+	case id >= 200 && id < 300:
+		return "rainandthunder"
+	case id >= 300 && id < 400:
+		return "lightrain"
+	case id == 500:
+		return "lightrain"
+	case id == 501:
+		return "rain"
+	case id >= 502 && id <= 504:
+		return "heavyrain"
+	case id == 511:
+		return "sleet"
+	case id == 520:
+		return "lightrainshowers"
+	case id == 521:
+		return "rainshowers"
+	case id >= 522 && id < 600:
+		return "heavyrainshowers"
+	case id == 600:
+		return "lightsnow"
+	case id == 601:
+		return "snow"
+	case id == 602:
+		return "heavysnow"
+	case id >= 611 && id <= 616:
+		return "sleet"
+	case id == 620:
+		return "lightsnowshowers"
+	case id == 621:
+		return "snowshowers"
+	case id == 622:
+		return "heavysnowshowers"
+	case id >= 700 && id < 800:
+		return "fog"
+	case id == 800:
+		return "clearsky"
+	case id == 801:
+		return "fair"
+	case id == 802:
+		return "partlycloudy"
+	case id == 803 || id == 804:
+		return "cloudy"
+	default:
+		return ""
+	}
+}
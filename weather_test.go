@@ -0,0 +1,312 @@
+package query
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSummarizeDays(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2020, 1, 1, 20, 0, 0, 0, time.UTC)
+	hour := func(h int, temp, rain float64, period time.Duration, code string) Weather {
+		return Weather{
+			Time:          start.Add(time.Duration(h) * time.Hour),
+			Temperature:   temp,
+			Precipitation: rain,
+			Period:        period,
+			SymbolCode:    code,
+			Symbol:        metSymbolName(code),
+		}
+	}
+
+	hours := []Weather{
+		hour(0, 1, 0.5, time.Hour, "cloudy"),
+		hour(1, -2, 0.5, time.Hour, "lightsnow"),
+		hour(2, -3, 0, time.Hour, "lightsnow"),
+		hour(3, -1, 0, time.Hour, "clearsky_night"),
+		hour(4, 0, 3, 6*time.Hour, "snow"),
+		hour(6, 2, 9, 6*time.Hour, "rain"), // overlaps the previous period
+		hour(10, 4, 1, 6*time.Hour, "rain"),
+		hour(28, 5, 0, 6*time.Hour, "fair_day"),
+		hour(52, 5, 0, 6*time.Hour, "fair_day"),
+	}
+
+	days := summarizeDays(hours, time.UTC, 2)
+	if len(days) != 2 {
+		t.Fatal("wrong number of days:", len(days))
+	}
+
+	first := days[0]
+	if !first.Date.Equal(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Error("first date was wrong:", first.Date)
+	}
+	if first.MinTemperature != -3 || first.MaxTemperature != 1 {
+		t.Error("first temperatures were wrong:", first.MinTemperature, first.MaxTemperature)
+	}
+	if first.Precipitation != 1 || first.SymbolCode != "lightsnow" || first.Symbol != "Light snow" {
+		t.Errorf("first day was wrong: %#v", first)
+	}
+
+	second := days[1]
+	if second.MinTemperature != 0 || second.MaxTemperature != 4 {
+		t.Error("second temperatures were wrong:", second.MinTemperature, second.MaxTemperature)
+	}
+	if second.Precipitation != 4 || second.SymbolCode != "rain" {
+		t.Errorf("second day was wrong: %#v", second)
+	}
+}
+
+func TestCompassPoint(t *testing.T) {
+	t.Parallel()
+
+	tests := map[float64]string{
+		0:     "N",
+		11:    "N",
+		12:    "NNE",
+		90:    "E",
+		200:   "SSW",
+		350:   "N",
+		-90:   "W",
+		405.0: "NE",
+	}
+
+	for degrees, want := range tests {
+		if got := compassPoint(degrees); got != want {
+			t.Errorf("%v: want %s got %s", degrees, want, got)
+		}
+	}
+}
+
+func TestFeelsLike(t *testing.T) {
+	t.Parallel()
+
+	if f := feelsLike(15, 10, 50); f != 15 {
+		t.Error("mild weather should feel like the temperature:", f)
+	}
+	if f := feelsLike(-10, 5, 50); f > -17 || f < -18 {
+		t.Error("wind chill was wrong:", f)
+	}
+	if f := feelsLike(32, 1, 70); f < 40 || f > 41 {
+		t.Error("heat index was wrong:", f)
+	}
+}
+
+type testWeatherProvider struct {
+	name string
+	err  error
+}
+
+func (t testWeatherProvider) Name() string { return t.name }
+
+func (t testWeatherProvider) Hourly(place Place, conf *Config) ([]Weather, error) {
+	if t.err != nil {
+		return nil, t.err
+	}
+	return []Weather{{Place: place, Time: time.Now(), Temperature: 20}}, nil
+}
+
+func TestForecastForFallback(t *testing.T) {
+	t.Parallel()
+
+	RegisterWeatherProvider("test-broken", testWeatherProvider{name: "broken", err: errors.New("down")})
+	RegisterWeatherProvider("test-ok", testWeatherProvider{name: "ok"})
+
+	conf := &Config{WeatherProviders: []string{"test-missing", "test-broken", "test-ok"}}
+	forecast, err := ForecastFor(Place{Name: "Oslo"}, 1, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if forecast.Provider != "ok" || forecast.Hourly[0].Provider != "ok" {
+		t.Error("wrong provider answered:", forecast.Provider)
+	}
+	if len(forecast.Daily) != 1 || forecast.Daily[0].MaxTemperature != 20 {
+		t.Errorf("daily summary was wrong: %#v", forecast.Daily)
+	}
+
+	conf.WeatherProviders = []string{"test-broken"}
+	if _, err = ForecastFor(Place{Name: "Oslo"}, 1, conf); err == nil || err.Error() != "down" {
+		t.Error("the last error should be returned:", err)
+	}
+}
+
+func TestOpenMeteoWeather(t *testing.T) {
+	t.Parallel()
+
+	js := `{"hourly": {
+		"time": ["2020-01-01T10:00", "2020-01-01T11:00", "2020-01-01T12:00", "2020-01-01T13:00"],
+		"temperature_2m": [1, 2, 3, null],
+		"precipitation": [0, 0.4],
+		"wind_speed_10m": [0, 3, null, 0],
+		"weather_code": [0, 61, 3, null]
+	}}`
+
+	var forecast openMeteoForecast
+	if err := json.Unmarshal([]byte(js), &forecast); err != nil {
+		t.Fatal(err)
+	}
+
+	hours, err := forecast.weather(Place{}, time.Date(2020, 1, 1, 11, 30, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hours) != 3 {
+		t.Fatal("past hours should be skipped:", len(hours))
+	}
+	if hours[0].Temperature != 2 || hours[0].Precipitation != 0.4 || hours[0].SymbolCode != "lightrain" {
+		t.Errorf("first hour was wrong: %#v", hours[0])
+	}
+	if known(hours[1].Precipitation) || known(hours[1].WindSpeed) || hours[1].Symbol != "Overcast" {
+		t.Errorf("missing values should be unknown: %#v", hours[1])
+	}
+	if known(hours[2].Temperature) || hours[2].WindSpeed != 0 || len(hours[2].SymbolCode) != 0 {
+		t.Errorf("null values should be unknown: %#v", hours[2])
+	}
+}
+
+func TestFormatWeatherDetails(t *testing.T) {
+	t.Parallel()
+
+	w := Weather{
+		WindSpeed:     5,
+		WindGust:      9,
+		WindDirection: 180,
+		Humidity:      50,
+		Pressure:      1013,
+		CloudCover:    20,
+		Precipitation: 0.4,
+	}
+	if out := formatWeatherDetails(w, Metric); out != ", wind 5.0 m/s S (gusts 9.0 m/s), humidity 50%, 1013 hPa, cloud cover 20%, precipitation 0.4 mm" {
+		t.Errorf("output was wrong: %q", out)
+	}
+
+	// NWS knows no gusts, pressure, cloud cover or precipitation.
+	w.WindGust, w.Pressure, w.CloudCover, w.Precipitation = unknown, unknown, unknown, unknown
+	if out := formatWeatherDetails(w, Metric); out != ", wind 5.0 m/s S, humidity 50%" {
+		t.Errorf("unknown details should be left out: %q", out)
+	}
+
+	w.WindSpeed, w.Humidity = unknown, unknown
+	if out := formatWeatherDetails(w, Metric); out != "" {
+		t.Errorf("unknown wind should be left out: %q", out)
+	}
+
+	days := summarizeDays([]Weather{{Time: time.Now(), Precipitation: unknown, Period: time.Hour}}, time.UTC, 1)
+	if len(days) != 1 || known(days[0].Precipitation) {
+		t.Fatalf("precipitation should be unknown: %#v", days)
+	}
+	if out := formatForecastDay(days[0], Metric); strings.Contains(out, "mm") {
+		t.Errorf("unknown precipitation should be left out: %q", out)
+	}
+}
+
+type testAlertProvider struct {
+	testWeatherProvider
+	alerts []WeatherAlert
+}
+
+func (t testAlertProvider) Covers(place Place) bool {
+	return place.CountryCode != "US"
+}
+
+func (t testAlertProvider) Alerts(place Place, conf *Config) ([]WeatherAlert, error) {
+	return t.alerts, nil
+}
+
+func TestAlertWatcher(t *testing.T) {
+	t.Parallel()
+
+	expires := time.Now().Add(time.Hour)
+	RegisterWeatherProvider("test-alerts", testAlertProvider{
+		testWeatherProvider: testWeatherProvider{name: "alerts"},
+		alerts: []WeatherAlert{
+			{ID: "1", Event: "Gale", Severity: "Moderate", Expires: expires},
+			{ID: "2", Headline: "Yellow warning for ice", Expires: expires},
+		},
+	})
+
+	conf := &Config{
+		WeatherAlertProviders: []string{"test-alerts"},
+		WeatherAlertSubscriptions: []AlertSubscription{
+			{Location: "Oslo", Channels: []string{"#a", "#b"}},
+		},
+	}
+
+	var said []string
+	watcher := NewAlertWatcher(conf, func(channel, message string) {
+		said = append(said, channel+" "+message)
+	})
+
+	if err := watcher.Check(); err != nil {
+		t.Fatal(err)
+	}
+	if len(said) != 4 {
+		t.Fatal("every alert should be announced in every channel:", said)
+	}
+	if !strings.HasPrefix(said[0], "#a \x02Weather alert (\x02alerts\x02):\x02 Oslo, Norway \x02=>\x02 Gale (Moderate) until ") {
+		t.Errorf("announcement was wrong: %q", said[0])
+	}
+
+	if err := watcher.Check(); err != nil {
+		t.Fatal(err)
+	}
+	if len(said) != 4 {
+		t.Error("alerts should only be announced once:", said)
+	}
+
+	// Alerts without an expiry are forgotten once they stop being returned.
+	RegisterWeatherProvider("test-alerts", testAlertProvider{
+		testWeatherProvider: testWeatherProvider{name: "alerts"},
+		alerts:              []WeatherAlert{{ID: "3", Event: "Fog"}},
+	})
+	if err := watcher.Check(); err != nil {
+		t.Fatal(err)
+	}
+	if len(said) != 6 || len(watcher.seen) != 3 {
+		t.Fatal("the new alert should be announced:", said)
+	}
+	for id := range watcher.seen {
+		watcher.seen[id] = time.Now().Add(-alertForgetAfter - time.Minute)
+	}
+	RegisterWeatherProvider("test-alerts", testAlertProvider{
+		testWeatherProvider: testWeatherProvider{name: "alerts"},
+	})
+	if err := watcher.Check(); err != nil {
+		t.Fatal(err)
+	}
+	if len(watcher.seen) != 0 {
+		t.Error("alerts no longer returned should be forgotten:", watcher.seen)
+	}
+}
+
+// TestAlertCoverage registers a provider so it can't run in parallel.
+func TestAlertCoverage(t *testing.T) {
+	oslo := placesLookup["oslo"]
+	paris := Place{Name: "Paris", CountryCode: "FR", Lat: 48.85, Lng: 2.35}
+	newYork := Place{Name: "New York", Lat: 40.71, Lng: -74.01}
+	toronto := Place{Name: "Toronto", CountryCode: "CA", Lat: 43.70, Lng: -79.42}
+
+	if !(metProvider{}).Covers(oslo) || (metProvider{}).Covers(paris) {
+		t.Error("met.no should only cover norway")
+	}
+	if !(nwsProvider{}).Covers(newYork) || (nwsProvider{}).Covers(toronto) || (nwsProvider{}).Covers(oslo) {
+		t.Error("nws should only cover the united states")
+	}
+
+	RegisterWeatherProvider("test-coverage", testAlertProvider{
+		testWeatherProvider: testWeatherProvider{name: "coverage"},
+		alerts:              []WeatherAlert{{ID: "1", Event: "Gale"}},
+	})
+	conf := &Config{WeatherAlertProviders: []string{"test-coverage"}}
+
+	alerts, err := WeatherAlerts(Place{CountryCode: "US"}, conf)
+	if err != nil || len(alerts) != 0 {
+		t.Errorf("providers should not be asked about places they don't cover: %v %v", alerts, err)
+	}
+	if alerts, err = WeatherAlerts(oslo, conf); err != nil || len(alerts) != 1 {
+		t.Errorf("covered places should have alerts: %v %v", alerts, err)
+	}
+}
@@ -7,10 +7,17 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
+	"time"
 )
 
+var wolframURI = "http://api.wolframalpha.com/v2/query?%s"
+
 const (
-	wolframURI = "http://api.wolframalpha.com/v2/query?format=plaintext&input=%s&appid=%s"
+	// wolframMaxLen is roughly how long a formatted result may be before
+	// further pods are left out.
+	wolframMaxLen = 400
 )
 
 // WolframData is used to parse the response from WolframAlpha.
@@ -21,79 +28,566 @@ type WolframData struct {
 	Numpods     int      `xml:"numpods,attr"`
 	Pods        []*Pod   `xml:"pod"`
 	DidYouMeans []string `xml:"didyoumeans>didyoumean"`
+
+	Assumptions []WolframAssumption `xml:"assumptions>assumption"`
+
+	// IsError is set when the query failed, Err says why.
+	IsError bool          `xml:"error,attr"`
+	Err     *WolframError `xml:"error"`
+
+	// Hints about why a query had no results.
+	Tips        []WolframTip        `xml:"tips>tip"`
+	FutureTopic *WolframFutureTopic `xml:"futuretopic"`
+	LanguageMsg *WolframLanguageMsg `xml:"languagemsg"`
+	ExamplePage *WolframExamplePage `xml:"examplepage"`
+}
+
+// WolframError is an error reported by WolframAlpha such as an invalid
+// appid.
+type WolframError struct {
+	Code int    `xml:"code"`
+	Msg  string `xml:"msg"`
+}
+
+func (w WolframError) Error() string {
+	return fmt.Sprintf("wolfram: %s (%d)", w.Msg, w.Code)
+}
+
+// WolframTip is advice on rephrasing a query WolframAlpha did not understand.
+type WolframTip struct {
+	Text string `xml:"text,attr"`
+}
+
+// WolframFutureTopic is returned for topics WolframAlpha does not cover yet.
+type WolframFutureTopic struct {
+	Topic string `xml:"topic,attr"`
+	Msg   string `xml:"msg,attr"`
+}
+
+// WolframLanguageMsg is returned for queries in languages WolframAlpha does
+// not understand. English and Other are the same message in english and the
+// language of the query.
+type WolframLanguageMsg struct {
+	English string `xml:"english,attr"`
+	Other   string `xml:"other,attr"`
+}
+
+// WolframExamplePage links to examples of the kind of query that was asked.
+type WolframExamplePage struct {
+	Category string `xml:"category,attr"`
+	URL      string `xml:"url,attr"`
+}
+
+// WolframAssumption is an interpretation WolframAlpha picked for part of an
+// ambiguous input. The first value is the one that was assumed, the rest are
+// alternatives.
+type WolframAssumption struct {
+	Type     string                   `xml:"type,attr"`
+	Word     string                   `xml:"word,attr"`
+	Template string                   `xml:"template,attr"`
+	Count    int                      `xml:"count,attr"`
+	Values   []WolframAssumptionValue `xml:"value"`
+}
+
+// WolframAssumptionValue is one interpretation. Input is the token to pass
+// back in WolframOptions.Assumptions to pick it.
+type WolframAssumptionValue struct {
+	Name  string `xml:"name,attr"`
+	Desc  string `xml:"desc,attr"`
+	Input string `xml:"input,attr"`
 }
 
 // Pod is a substruct of WolframData.
 type Pod struct {
 	Title      string   `xml:"title,attr"`
 	ID         string   `xml:"id,attr"`
+	Scanner    string   `xml:"scanner,attr"`
+	Position   int      `xml:"position,attr"`
 	Primary    bool     `xml:"primary,attr"`
 	Numsubpods int      `xml:"numsubpods,attr"`
-	PlainTexts []string `xml:"subpod>plaintext"`
+	Subpods    []Subpod `xml:"subpod"`
+
+	// PlainTexts are the plaintexts of the Subpods, kept for callers from
+	// before Subpods existed.
+	//
+	// Deprecated: use Subpods or Text.
+	PlainTexts []string `xml:"-"`
+
+	// States are alternate versions of the pod such as a step-by-step
+	// solution, see WolframOptions.PodStates.
+	States []WolframState `xml:"states>state"`
 }
 
-// Wolfram performs a query and returns a formatted result.
-func Wolfram(query string, conf *Config) (output string, err error) {
+// UnmarshalXML decodes the pod and fills in PlainTexts from its subpods,
+// encoding/xml can't decode subpod twice.
+func (p *Pod) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	type pod Pod
+	if err := d.DecodeElement((*pod)(p), &start); err != nil {
+		return err
+	}
+
+	p.PlainTexts = make([]string, len(p.Subpods))
+	for i, s := range p.Subpods {
+		p.PlainTexts[i] = s.Plaintext
+	}
+	return nil
+}
+
+// Subpod is a substruct of Pod.
+type Subpod struct {
+	Title     string `xml:"title,attr"`
+	Plaintext string `xml:"plaintext"`
+
+	// Image is only set when images were asked for with
+	// WolframOptions.Images.
+	Image *WolframImage `xml:"img"`
+}
+
+// WolframImage is a rendering of a subpod, such as a plot.
+type WolframImage struct {
+	Src    string `xml:"src,attr"`
+	Alt    string `xml:"alt,attr"`
+	Title  string `xml:"title,attr"`
+	Width  int    `xml:"width,attr"`
+	Height int    `xml:"height,attr"`
+}
+
+// WolframState is an alternate version of a pod. Input is what to pass in
+// WolframOptions.PodStates to get it.
+type WolframState struct {
+	Name  string `xml:"name,attr"`
+	Input string `xml:"input,attr"`
+}
+
+// Text returns the plaintext of every subpod on a single line.
+func (p *Pod) Text() string {
+	var texts []string
+	for _, s := range p.Subpods {
+		text := strings.TrimSpace(s.Plaintext)
+		if len(text) == 0 {
+			continue
+		}
+		if len(s.Title) != 0 {
+			text = s.Title + ": " + text
+		}
+		texts = append(texts, strings.Replace(text, "\n", "; ", -1))
+	}
+
+	return strings.Join(texts, "; ")
+}
+
+// Images returns the images of every subpod that has one.
+func (p *Pod) Images() []WolframImage {
+	var images []WolframImage
+	for _, s := range p.Subpods {
+		if s.Image != nil {
+			images = append(images, *s.Image)
+		}
+	}
+
+	return images
+}
+
+// Input returns the pod with the input interpretation, the first pod unless
+// that is the answer itself. It is nil when there is no such pod.
+func (w *WolframData) Input() *Pod {
+	if len(w.Pods) == 0 || w.Pods[0].Primary {
+		return nil
+	}
+	return w.Pods[0]
+}
+
+// Primary returns the pod WolframAlpha considers the answer, or the first
+// pod after the input interpretation when none is marked primary. It is nil
+// when there is no such pod.
+func (w *WolframData) Primary() *Pod {
+	for _, p := range w.Pods {
+		if p.Primary {
+			return p
+		}
+	}
+	if len(w.Pods) > 1 {
+		return w.Pods[1]
+	}
+	return nil
+}
+
+// AssumptionToken finds the token of the interpretation whose name or
+// description matches choice, for example "element" for mercury.
+func (w *WolframData) AssumptionToken(choice string) (string, bool) {
+	choice = strings.ToLower(strings.TrimSpace(choice))
+	if len(choice) == 0 {
+		return "", false
+	}
+
+	for _, a := range w.Assumptions {
+		for _, v := range a.Values {
+			if strings.ToLower(v.Name) == choice {
+				return v.Input, true
+			}
+		}
+	}
+	for _, a := range w.Assumptions {
+		for _, v := range a.Values {
+			if strings.Contains(strings.ToLower(v.Desc), choice) {
+				return v.Input, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// WolframOptions select which pods WolframAlpha computes. Pod ids are
+// values like "Result" or "Input", titles may end in * as a wildcard.
+type WolframOptions struct {
+	IncludePodIDs []string
+	ExcludePodIDs []string
+	PodTitles     []string
+
+	// Assumptions are tokens from WolframAssumptionValue.Input used to pick
+	// an interpretation of an ambiguous input.
+	Assumptions []string
+
+	// Units is either metric or nonmetric.
+	Units string
+	// Location is where the query is asked from, as a place name.
+	Location string
+	// IP is where the query is asked from, as an ip address.
+	IP string
+	// LatLong is where the query is asked from, for example "59.91,10.75".
+	LatLong string
+
+	// ScanTimeout, PodTimeout and TotalTimeout bound how long WolframAlpha
+	// spends on a query.
+	ScanTimeout  time.Duration
+	PodTimeout   time.Duration
+	TotalTimeout time.Duration
+
+	// Reinterpret lets WolframAlpha rewrite queries it does not understand.
+	// When nil wolfram_reinterpret in the config decides.
+	Reinterpret *bool
+
+	// Images asks for an image of every subpod alongside its plaintext.
+	Images bool
+	// StepByStep asks for step-by-step solutions of math problems.
+	StepByStep bool
+	// PodStates are WolframState inputs to apply.
+	PodStates []string
+}
+
+// withDefaults fills in the unset options from the config.
+func (w WolframOptions) withDefaults(conf *Config) WolframOptions {
+	seconds := func(d time.Duration, s float64) time.Duration {
+		if d != 0 {
+			return d
+		}
+		return time.Duration(s * float64(time.Second))
+	}
+
+	// Without units WolframAlpha picks them from where the query is asked,
+	// so only send what is configured.
+	if len(w.Units) == 0 {
+		if units, ok := ParseUnits(conf.Units); ok {
+			w.Units = units.wolfram()
+		} else {
+			w.Units = conf.WolframUnits
+		}
+	}
+	if len(w.Location) == 0 && len(w.IP) == 0 && len(w.LatLong) == 0 {
+		// Saved locations may be aliases, geohashes or plus codes that
+		// WolframAlpha can't read, so send where they are instead.
+		if place, ok := userWolframPlace(conf); ok {
+			w.LatLong = fmt.Sprintf("%.4f,%.4f", place.Lat, place.Lng)
+		} else {
+			w.Location = conf.WolframLocation
+		}
+	}
+	w.ScanTimeout = seconds(w.ScanTimeout, conf.WolframScanTimeout)
+	w.PodTimeout = seconds(w.PodTimeout, conf.WolframPodTimeout)
+	w.TotalTimeout = seconds(w.TotalTimeout, conf.WolframTotalTimeout)
+	if w.Reinterpret == nil {
+		reinterpret := conf.WolframReinterpret
+		w.Reinterpret = &reinterpret
+	}
+
+	return w
+}
+
+// userWolframPlace returns the place the user saved, if any.
+func userWolframPlace(conf *Config) (Place, bool) {
+	place, ok, err := conf.userPlace()
+	if err != nil {
+		conf.log().Warn("saved location could not be read", "err", err)
+		return Place{}, false
+	}
+	return place, ok
+}
+
+func (w WolframOptions) encode(params url.Values) {
+	timeout := func(key string, d time.Duration) {
+		if d > 0 {
+			params.Set(key, strconv.FormatFloat(d.Seconds(), 'f', -1, 64))
+		}
+	}
+
+	setNonEmpty(params, "units", w.Units)
+	setNonEmpty(params, "location", w.Location)
+	setNonEmpty(params, "ip", w.IP)
+	setNonEmpty(params, "latlong", w.LatLong)
+	timeout("scantimeout", w.ScanTimeout)
+	timeout("podtimeout", w.PodTimeout)
+	timeout("totaltimeout", w.TotalTimeout)
+	if w.Reinterpret != nil && *w.Reinterpret {
+		params.Set("reinterpret", "true")
+	}
+
+	for _, id := range w.IncludePodIDs {
+		params.Add("includepodid", id)
+	}
+	for _, id := range w.ExcludePodIDs {
+		params.Add("excludepodid", id)
+	}
+	for _, title := range w.PodTitles {
+		params.Add("podtitle", title)
+	}
+	for _, assumption := range w.Assumptions {
+		params.Add("assumption", assumption)
+	}
+
+	if w.Images {
+		params.Set("format", "plaintext,image")
+	}
+	if w.StepByStep {
+		params.Add("podstate", "Step-by-step solution")
+	}
+	for _, state := range w.PodStates {
+		params.Add("podstate", state)
+	}
+}
+
+// WolframResults performs a query and returns the decoded response. Options
+// left unset are taken from the config.
+func WolframResults(query string, opts WolframOptions, conf *Config) (*WolframData, error) {
 	if len(conf.WolframID) == 0 {
-		return output, errors.New("cannot use wolfram without wolfram_id")
+		return nil, errors.New("cannot use wolfram without wolfram_id")
 	}
 
-	requestURI := fmt.Sprintf(wolframURI, url.QueryEscape(query), conf.WolframID)
+	opts = opts.withDefaults(conf)
 
-	var resp *http.Response
-	if resp, err = http.Get(requestURI); err != nil {
-		return output, err
+	params := make(url.Values)
+	params.Set("input", query)
+	params.Set("appid", conf.WolframID)
+	params.Set("format", "plaintext")
+	opts.encode(params)
+
+	client := http.Client{}
+	if opts.TotalTimeout > 0 {
+		// Give WolframAlpha a moment past its own deadline to respond with
+		// whatever it managed to compute.
+		client.Timeout = opts.TotalTimeout + 5*time.Second
+	}
+
+	resp, err := client.Get(fmt.Sprintf(wolframURI, params.Encode()))
+	if err != nil {
+		return nil, err
 	}
 
+	defer resp.Body.Close()
+
+	conf.log().Debug("wolfram query", "query", query, "status", resp.StatusCode)
+
 	if resp.StatusCode != http.StatusOK {
-		output = fmt.Sprintf("\x02Wolfram:\x02 Server response was %d", resp.StatusCode)
-		return output, nil
+		conf.log().Warn("wolfram query failed", "status", resp.StatusCode)
+		return nil, StatusError{Service: "wolfram", StatusCode: resp.StatusCode}
 	}
 
-	var body []byte
-	body, err = ioutil.ReadAll(resp.Body)
+	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return output, err
+		return nil, err
 	}
-	_ = resp.Body.Close()
 
 	var xmlObj WolframData
-	err = xml.Unmarshal(body, &xmlObj)
+	if err = xml.Unmarshal(body, &xmlObj); err != nil {
+		return nil, err
+	}
+
+	if xmlObj.IsError && xmlObj.Err != nil {
+		conf.log().Warn("wolfram error", "code", xmlObj.Err.Code, "msg", xmlObj.Err.Msg)
+		return nil, *xmlObj.Err
+	}
+
+	return &xmlObj, nil
+}
+
+// Wolfram performs a query and returns a formatted result. An
+// interpretation of an ambiguous query can be chosen by ending it with
+// --as and the interpretation's name or token, for example
+// "mercury --as element". The units can be chosen with --metric or
+// --imperial.
+//
+// When wolfram_mode is short or spoken the one line answer of that API is
+// used, falling back to the full query when there is none.
+func Wolfram(query string, conf *Config) (output string, err error) {
+	var opts WolframOptions
+	if q, units := parseUnitsFlag(query, ""); len(units) != 0 {
+		query, opts.Units = q, units.wolfram()
+	}
+	query, choice := splitWolframChoice(query)
+
+	if mode := WolframMode(conf.WolframMode); len(choice) == 0 && (mode == WolframShort || mode == WolframSpoken) {
+		answer, err := WolframAnswer(query, mode, opts, conf)
+		switch {
+		case err == nil:
+			return fmt.Sprintf("\x02Wolfram:\x02 %s", answer), nil
+		case err != ErrWolframNoAnswer:
+			if e, ok := err.(StatusError); ok {
+				return fmt.Sprintf("\x02Wolfram:\x02 Server response was %d", e.StatusCode), nil
+			}
+			return "", err
+		}
+	}
+
+	xmlObj, err := WolframResults(query, opts, conf)
+	if err == nil && len(choice) != 0 {
+		token := choice
+		if !strings.HasPrefix(choice, "*") {
+			token, _ = xmlObj.AssumptionToken(choice)
+		}
+		if len(token) != 0 {
+			opts.Assumptions = append(opts.Assumptions, token)
+			xmlObj, err = WolframResults(query, opts, conf)
+		}
+	}
 	if err != nil {
+		switch e := err.(type) {
+		case StatusError:
+			return fmt.Sprintf("\x02Wolfram:\x02 Server response was %d", e.StatusCode), nil
+		case WolframError:
+			return fmt.Sprintf("\x02Wolfram:\x02 Error %d: %s", e.Code, e.Msg), nil
+		}
 		return output, err
 	}
 
+	return formatWolfram(query, xmlObj, wolframMaxLen, conf.wolframCommand()), nil
+}
+
+// splitWolframChoice splits "mercury --as element" into the query and the
+// chosen interpretation.
+func splitWolframChoice(query string) (string, string) {
+	i := strings.LastIndex(query, "--as ")
+	if i < 0 || (i > 0 && query[i-1] != ' ') {
+		return query, ""
+	}
+
+	return strings.TrimSpace(query[:i]), strings.TrimSpace(query[i+len("--as "):])
+}
+
+// formatWolfram renders the input interpretation followed by the primary
+// pod, then how to choose another interpretation and as many other pods as
+// fit in budget bytes. command is how users invoke Wolfram.
+func formatWolfram(query string, xmlObj *WolframData, budget int, command string) string {
 	// Handle cases of no results.
 	if !xmlObj.Success {
 		if len(xmlObj.DidYouMeans) > 0 {
-			output = fmt.Sprintf("\x02Wolfram (\x02%.2fms\x02):\x02 Did you mean: %s",
+			return fmt.Sprintf("\x02Wolfram (\x02%.2fms\x02):\x02 Did you mean: %s",
 				xmlObj.ParseTiming,
 				xmlObj.DidYouMeans[0],
 			)
-		} else {
-			output = fmt.Sprintf("\x02Wolfram (\x02%.2fms\x02):\x02 No results found.",
-				xmlObj.ParseTiming)
 		}
-		return output, nil
+		if xmlObj.FutureTopic != nil {
+			return fmt.Sprintf("\x02Wolfram (\x02%.2fms\x02):\x02 %s: %s",
+				xmlObj.ParseTiming,
+				xmlObj.FutureTopic.Topic,
+				xmlObj.FutureTopic.Msg,
+			)
+		}
+
+		output := fmt.Sprintf("\x02Wolfram (\x02%.2fms\x02):\x02 No results found.",
+			xmlObj.ParseTiming)
+		switch {
+		case xmlObj.LanguageMsg != nil:
+			output += " " + xmlObj.LanguageMsg.English
+		case len(xmlObj.Tips) > 0:
+			output += " Tip: " + xmlObj.Tips[0].Text
+		case xmlObj.ExamplePage != nil:
+			output += " Examples: " + xmlObj.ExamplePage.URL
+		}
+		return output
 	}
 
+	var input string
+	if pod := xmlObj.Input(); pod != nil {
+		input = pod.Text()
+	}
+	if len(input) == 0 {
+		input = query
+	}
+
+	primary := xmlObj.Primary()
+
 	// If there was no primary response fallback to link.
-	if len(xmlObj.Pods) < 2 || len(xmlObj.Pods[1].PlainTexts[0]) == 0 {
-		output = fmt.Sprintf(
+	if primary == nil || len(primary.Text()) == 0 {
+		return fmt.Sprintf(
 			"\x02Wolfram (\x02%.2fms\x02):\x02 %s \x02=>\x02 http://www.wolframalpha.com/input/?i=%s",
 			xmlObj.ParseTiming,
-			xmlObj.Pods[0].PlainTexts[0],
+			input,
 			url.QueryEscape(query),
 		)
-		return output, nil
 	}
 
-	output = fmt.Sprintf(
+	output := fmt.Sprintf(
 		"\x02Wolfram (\x02%.2fms\x02):\x02 %s \x02=>\x02 %s",
 		xmlObj.ParseTiming,
-		xmlObj.Pods[0].PlainTexts[0],
-		xmlObj.Pods[1].PlainTexts[0],
+		input,
+		primary.Text(),
 	)
 
-	return
+	if hint := wolframAssumptionHint(query, xmlObj, command); len(hint) != 0 {
+		output += " \x02|\x02 " + hint
+	}
+
+	for _, pod := range xmlObj.Pods {
+		if pod == primary || pod == xmlObj.Input() {
+			continue
+		}
+
+		text := pod.Text()
+		if len(text) == 0 {
+			continue
+		}
+
+		more := fmt.Sprintf(" \x02|\x02 %s: %s", pod.Title, text)
+		if len(output)+len(more) > budget {
+			break
+		}
+		output += more
+	}
+
+	return output
+}
+
+// wolframAssumptionHint explains the interpretation of an ambiguous query and
+// how to pick the first alternative, for example
+// "Assuming a planet; use !wa mercury --as element".
+func wolframAssumptionHint(query string, xmlObj *WolframData, command string) string {
+	for _, a := range xmlObj.Assumptions {
+		if len(a.Values) < 2 {
+			continue
+		}
+
+		alt := a.Values[1]
+		choice := strings.ToLower(alt.Name)
+		if words := strings.Fields(alt.Desc); len(words) > 0 {
+			last := strings.ToLower(words[len(words)-1])
+			if token, ok := xmlObj.AssumptionToken(last); ok && token == alt.Input {
+				choice = last
+			}
+		}
+
+		return fmt.Sprintf("Assuming %s; use %s %s --as %s",
+			a.Values[0].Desc, command, query, choice)
+	}
+
+	return ""
 }
@@ -0,0 +1,89 @@
+package query
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var wolframShortURI = "http://api.wolframalpha.com/v1/%s?%s"
+
+// WolframMode selects which WolframAlpha API answers a query.
+type WolframMode string
+
+// The WolframAlpha APIs. WolframFull is the full query API, WolframShort the
+// Short Answers API and WolframSpoken the Spoken Results API.
+const (
+	WolframFull   WolframMode = "full"
+	WolframShort  WolframMode = "short"
+	WolframSpoken WolframMode = "spoken"
+)
+
+// ErrWolframNoAnswer is returned by WolframAnswer when WolframAlpha has no
+// short answer for a query. The full query API may still have results.
+var ErrWolframNoAnswer = errors.New("wolfram: no short answer available")
+
+// WolframAnswer asks the Short Answers or Spoken Results API and returns its
+// single line answer. Only the units, location and total timeout options
+// apply.
+func WolframAnswer(query string, mode WolframMode, opts WolframOptions, conf *Config) (string, error) {
+	if len(conf.WolframID) == 0 {
+		return "", errors.New("cannot use wolfram without wolfram_id")
+	}
+
+	var endpoint string
+	switch mode {
+	case WolframShort:
+		endpoint = "result"
+	case WolframSpoken:
+		endpoint = "spoken"
+	default:
+		return "", fmt.Errorf("wolfram: %q has no short answer api", mode)
+	}
+
+	opts = opts.withDefaults(conf)
+
+	params := make(url.Values)
+	params.Set("appid", conf.WolframID)
+	params.Set("i", query)
+	setNonEmpty(params, "units", opts.Units)
+	setNonEmpty(params, "location", opts.Location)
+	setNonEmpty(params, "ip", opts.IP)
+	setNonEmpty(params, "latlong", opts.LatLong)
+
+	client := http.Client{}
+	if opts.TotalTimeout > 0 {
+		params.Set("timeout", strconv.Itoa(int(opts.TotalTimeout.Seconds())))
+		client.Timeout = opts.TotalTimeout + 5*time.Second
+	}
+
+	resp, err := client.Get(fmt.Sprintf(wolframShortURI, endpoint, params.Encode()))
+	if err != nil {
+		return "", err
+	}
+
+	defer resp.Body.Close()
+
+	conf.log().Debug("wolfram short query", "mode", mode, "query", query, "status", resp.StatusCode)
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotImplemented:
+		return "", ErrWolframNoAnswer
+	default:
+		conf.log().Warn("wolfram short query failed", "mode", mode, "status", resp.StatusCode)
+		return "", StatusError{Service: "wolfram", StatusCode: resp.StatusCode}
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(body)), nil
+}
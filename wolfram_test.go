@@ -0,0 +1,369 @@
+package query
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const wolframTestXML = `<queryresult success="true" parsetiming="0.5" numpods="4">
+	<pod title="Input interpretation" id="Input" position="100">
+		<subpod title=""><plaintext>population | Norway</plaintext></subpod>
+	</pod>
+	<pod title="Empty" id="Empty" position="150"></pod>
+	<pod title="Result" id="Result" position="200" primary="true">
+		<subpod title=""><plaintext>5.4 million people</plaintext></subpod>
+	</pod>
+	<pod title="History" id="History" position="300">
+		<subpod title="a"><plaintext>1990 | 4.2 million
+2000 | 4.5 million</plaintext></subpod>
+	</pod>
+</queryresult>`
+
+func TestFormatWolfram(t *testing.T) {
+	t.Parallel()
+
+	var data WolframData
+	if err := xml.Unmarshal([]byte(wolframTestXML), &data); err != nil {
+		t.Fatal(err)
+	}
+
+	if p := data.Primary(); p == nil || p.ID != "Result" {
+		t.Error("primary pod was wrong:", p)
+	}
+	if p := data.Pods[3]; len(p.PlainTexts) != 1 || p.PlainTexts[0] != p.Subpods[0].Plaintext {
+		t.Errorf("plaintexts should be kept for old callers: %#v", p.PlainTexts)
+	}
+
+	out := formatWolfram("norway population", &data, 400, "!wa")
+	if out != "\x02Wolfram (\x020.50ms\x02):\x02 population | Norway \x02=>\x02 5.4 million people \x02|\x02 History: a: 1990 | 4.2 million; 2000 | 4.5 million" {
+		t.Errorf("output was wrong: %q", out)
+	}
+
+	out = formatWolfram("norway population", &data, 60, "!wa")
+	if out != "\x02Wolfram (\x020.50ms\x02):\x02 population | Norway \x02=>\x02 5.4 million people" {
+		t.Errorf("output was wrong: %q", out)
+	}
+}
+
+func TestFormatWolframOnlyPrimary(t *testing.T) {
+	t.Parallel()
+
+	var data WolframData
+	err := xml.Unmarshal([]byte(`<queryresult success="true" numpods="1">
+	<pod title="Result" id="Result" primary="true">
+		<subpod title=""><plaintext>42</plaintext></subpod>
+	</pod>
+</queryresult>`), &data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data.Input() != nil {
+		t.Error("the primary pod is not the input")
+	}
+	out := formatWolfram("answer to everything", &data, 400, "!wa")
+	if out != "\x02Wolfram (\x020.00ms\x02):\x02 answer to everything \x02=>\x02 42" {
+		t.Errorf("output was wrong: %q", out)
+	}
+}
+
+func TestWolframAssumptions(t *testing.T) {
+	t.Parallel()
+
+	js := `<queryresult success="true" parsetiming="0.1">
+		<pod title="Input interpretation" id="Input"><subpod><plaintext>Mercury (planet)</plaintext></subpod></pod>
+		<pod title="Properties" id="Properties" primary="true"><subpod><plaintext>radius 2440 km</plaintext></subpod></pod>
+		<assumptions count="1">
+			<assumption type="Clash" word="mercury" count="2">
+				<value name="Planet" desc="a planet" input="*C.mercury-_*Planet-"/>
+				<value name="Element" desc="a chemical element" input="*C.mercury-_*Element-"/>
+			</assumption>
+		</assumptions>
+	</queryresult>`
+
+	var data WolframData
+	if err := xml.Unmarshal([]byte(js), &data); err != nil {
+		t.Fatal(err)
+	}
+
+	if token, ok := data.AssumptionToken("chemical"); !ok || token != "*C.mercury-_*Element-" {
+		t.Error("token was wrong:", token)
+	}
+	if _, ok := data.AssumptionToken("dog"); ok {
+		t.Error("should not find a token")
+	}
+
+	out := formatWolfram("mercury", &data, 400, "!wa")
+	if out != "\x02Wolfram (\x020.10ms\x02):\x02 Mercury (planet) \x02=>\x02 radius 2440 km \x02|\x02 Assuming a planet; use !wa mercury --as element" {
+		t.Errorf("output was wrong: %q", out)
+	}
+
+	for in, want := range map[string][2]string{
+		"mercury --as element": {"mercury", "element"},
+		"mercury":              {"mercury", ""},
+		"x--as y":              {"x--as y", ""},
+	} {
+		query, choice := splitWolframChoice(in)
+		if query != want[0] || choice != want[1] {
+			t.Errorf("%q split wrong: %q %q", in, query, choice)
+		}
+	}
+}
+
+func TestWolframImagesAndStates(t *testing.T) {
+	t.Parallel()
+
+	js := `<queryresult success="true" parsetiming="0.2">
+		<pod title="Input" id="Input"><subpod><plaintext>solve x^2 = 4</plaintext></subpod></pod>
+		<pod title="Results" id="Result" primary="true" numsubpods="2">
+			<subpod title="">
+				<plaintext>x = -2</plaintext>
+				<img src="https://www5b.wolframalpha.com/Calculate/MSP/MSP1.gif" alt="x = -2" title="x = -2" width="46" height="18"/>
+			</subpod>
+			<subpod title=""><plaintext>x = 2</plaintext></subpod>
+			<states count="1">
+				<state name="Step-by-step solution" input="Result__Step-by-step solution"/>
+			</states>
+		</pod>
+	</queryresult>`
+
+	var data WolframData
+	if err := xml.Unmarshal([]byte(js), &data); err != nil {
+		t.Fatal(err)
+	}
+	if len(data.Pods) != 2 {
+		t.Fatal("wrong number of pods:", len(data.Pods))
+	}
+	pod := data.Pods[1]
+
+	want := WolframImage{
+		Src:    "https://www5b.wolframalpha.com/Calculate/MSP/MSP1.gif",
+		Alt:    "x = -2",
+		Title:  "x = -2",
+		Width:  46,
+		Height: 18,
+	}
+	if img := pod.Subpods[0].Image; img == nil || *img != want {
+		t.Errorf("image was wrong: %#v", img)
+	}
+	if pod.Subpods[1].Image != nil {
+		t.Error("a subpod without img should have no image")
+	}
+	if images := pod.Images(); !reflect.DeepEqual(images, []WolframImage{want}) {
+		t.Errorf("images were wrong: %#v", images)
+	}
+
+	states := []WolframState{{Name: "Step-by-step solution", Input: "Result__Step-by-step solution"}}
+	if !reflect.DeepEqual(pod.States, states) {
+		t.Errorf("states were wrong: %#v", pod.States)
+	}
+	if len(data.Pods[0].States) != 0 {
+		t.Error("a pod without states should have none")
+	}
+}
+
+func TestWolframErrorsAndHints(t *testing.T) {
+	t.Parallel()
+
+	var data WolframData
+	err := xml.Unmarshal([]byte(`<queryresult success="false" error="true"><error><code>1</code><msg>Invalid appid</msg></error></queryresult>`), &data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !data.IsError || data.Err == nil || data.Err.Code != 1 || data.Err.Msg != "Invalid appid" {
+		t.Errorf("error was wrong: %#v", data.Err)
+	}
+
+	data = WolframData{}
+	err = xml.Unmarshal([]byte(`<queryresult success="false" error="false" parsetiming="0.1">
+		<tips count="1"><tip text="Check your spelling, and use English"/></tips>
+	</queryresult>`), &data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out := formatWolfram("asdf", &data, 400, "!wa"); out != "\x02Wolfram (\x020.10ms\x02):\x02 No results found. Tip: Check your spelling, and use English" {
+		t.Errorf("output was wrong: %q", out)
+	}
+
+	data = WolframData{}
+	err = xml.Unmarshal([]byte(`<queryresult success="false" parsetiming="0.1">
+		<futuretopic topic="Operating Systems" msg="Development of this topic is under investigation..."/>
+	</queryresult>`), &data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out := formatWolfram("windows", &data, 400, "!wa"); out != "\x02Wolfram (\x020.10ms\x02):\x02 Operating Systems: Development of this topic is under investigation..." {
+		t.Errorf("output was wrong: %q", out)
+	}
+}
+
+func TestWolframOptionsEncode(t *testing.T) {
+	t.Parallel()
+
+	opts := WolframOptions{
+		ExcludePodIDs: []string{"Input"},
+		Units:         "nonmetric",
+		LatLong:       "59.91,10.75",
+		ScanTimeout:   1500 * time.Millisecond,
+		TotalTimeout:  20 * time.Second,
+		Images:        true,
+		StepByStep:    true,
+		PodStates:     []string{"Result__More"},
+	}
+
+	params := make(url.Values)
+	params.Set("format", "plaintext")
+	opts.encode(params)
+
+	want := url.Values{
+		"excludepodid": {"Input"},
+		"units":        {"nonmetric"},
+		"latlong":      {"59.91,10.75"},
+		"scantimeout":  {"1.5"},
+		"totaltimeout": {"20"},
+		"format":       {"plaintext,image"},
+		"podstate":     {"Step-by-step solution", "Result__More"},
+	}
+	if !reflect.DeepEqual(params, want) {
+		t.Errorf("params were wrong:\nwant %v\ngot  %v", want, params)
+	}
+}
+
+func TestWolframUnitsDefaults(t *testing.T) {
+	t.Parallel()
+
+	conf := &Config{
+		WolframUnits: "metric",
+		ChannelUnits: map[string]string{"#us": "imperial"},
+	}
+
+	tests := []struct {
+		Conf  *Config
+		Units string
+		Want  string
+	}{
+		{&Config{}, "", ""},
+		{conf, "", "metric"},
+		{conf.ForChannel("#us"), "", "nonmetric"},
+		{&Config{Units: "imperial", WolframUnits: "metric"}, "", "nonmetric"},
+		{conf.ForChannel("#us"), "metric", "metric"},
+	}
+
+	for i, test := range tests {
+		opts := WolframOptions{Units: test.Units}.withDefaults(test.Conf)
+		if opts.Units != test.Want {
+			t.Errorf("%d: want units %q, got %q", i, test.Want, opts.Units)
+		}
+	}
+}
+
+func TestWolframReinterpretOverride(t *testing.T) {
+	t.Parallel()
+
+	conf := &Config{WolframReinterpret: true}
+	off, on := false, true
+
+	tests := []struct {
+		Reinterpret *bool
+		Conf        *Config
+		Want        string
+	}{
+		{nil, conf, "true"},
+		{&off, conf, ""},
+		{nil, &Config{}, ""},
+		{&on, &Config{}, "true"},
+	}
+
+	for i, test := range tests {
+		params := make(url.Values)
+		WolframOptions{Reinterpret: test.Reinterpret}.withDefaults(test.Conf).encode(params)
+		if got := params.Get("reinterpret"); got != test.Want {
+			t.Errorf("%d: want reinterpret %q, got %q", i, test.Want, got)
+		}
+	}
+}
+
+func TestWolframSavedLocation(t *testing.T) {
+	t.Parallel()
+
+	prefs, err := NewFilePrefStore(filepath.Join(t.TempDir(), "prefs.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := &Config{
+		Prefs:           prefs,
+		WolframLocation: "Oslo",
+		Places: map[string]PlaceOverride{
+			"hq": {Name: "HQ", Lat: 59.91273, Lng: 10.74609},
+		},
+	}
+	if _, err = conf.ForUser("bob").setUserLocation("hq"); err != nil {
+		t.Fatal(err)
+	}
+	// The place saved with the location is used, it isn't resolved again.
+	conf.Places = nil
+
+	opts := WolframOptions{}.withDefaults(conf.ForUser("bob"))
+	if opts.LatLong != "59.9127,10.7461" || len(opts.Location) != 0 {
+		t.Errorf("saved location should be sent as coordinates: %#v", opts)
+	}
+
+	opts = WolframOptions{}.withDefaults(conf.ForUser("alice"))
+	if opts.Location != "Oslo" || len(opts.LatLong) != 0 {
+		t.Errorf("wolfram_location should be used without a saved location: %#v", opts)
+	}
+}
+
+// TestWolframShortFallback swaps the wolfram uris so it can't run in
+// parallel.
+func TestWolframShortFallback(t *testing.T) {
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		switch r.URL.Path {
+		case "/v1/result":
+			w.WriteHeader(http.StatusNotImplemented)
+			fmt.Fprint(w, "No short answer available")
+		case "/v2/query":
+			if r.URL.Query().Get("input") != "norway population" {
+				t.Error("query was wrong:", r.URL.RawQuery)
+			}
+			fmt.Fprint(w, wolframTestXML)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	oldURI, oldShortURI := wolframURI, wolframShortURI
+	wolframURI, wolframShortURI = srv.URL+"/v2/query?%s", srv.URL+"/v1/%s?%s"
+	defer func() { wolframURI, wolframShortURI = oldURI, oldShortURI }()
+
+	conf := &Config{WolframID: "id", WolframMode: string(WolframShort)}
+
+	_, err := WolframAnswer("norway population", WolframShort, WolframOptions{}, conf)
+	if err != ErrWolframNoAnswer {
+		t.Error("501 should be no answer:", err)
+	}
+
+	paths = nil
+	out, err := Wolfram("norway population", conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"/v1/result", "/v2/query"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("want requests to %v, got %v", want, paths)
+	}
+	if !strings.Contains(out, "5.4 million people") {
+		t.Errorf("full results were not used: %q", out)
+	}
+}
@@ -59,6 +59,8 @@ func YouTube(msg string, cfg *Config) (output string, err error) {
 	}
 	defer resp.Body.Close()
 
+	cfg.log().Debug("youtube query", "id", id, "status", resp.StatusCode)
+
 	if resp.StatusCode == http.StatusNotFound {
 		return "", nil
 	} else if resp.StatusCode != http.StatusOK {